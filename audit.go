@@ -0,0 +1,156 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DownloadAuthorizer decides whether a request may download the file name. When it refuses, status is
+// the HTTP status to answer with, such as 401 Unauthorized or 403 Forbidden.
+type DownloadAuthorizer func(r *http.Request, name string) (allowed bool, status int)
+
+// DownloadRecord describes a completed download, for auditing
+type DownloadRecord struct {
+	Time        time.Time `json:"time"`
+	File        string    `json:"file"`
+	Files       []string  `json:"files,omitempty"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Method      string    `json:"method"`
+	Status      int       `json:"status"`
+	BytesSent   int64     `json:"bytesSent"`
+	// Duration is encoded in JSON as a number of nanoseconds
+	Duration time.Duration `json:"duration"`
+	Range    string        `json:"range,omitempty"`
+	ClientIP string        `json:"clientIP"`
+}
+
+// auditWriter records the status and the number of body bytes of a response
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *auditWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *auditWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(p)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (aw *auditWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
+
+// startAudit wraps w to record the response when Tools.DownloadAudit is set. The returned function
+// completes the record and hands it to the audit callback once the response is sent.
+func (tools *Tools) startAudit(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(rec DownloadRecord)) {
+	if tools.DownloadAudit == nil {
+		return w, func(DownloadRecord) {}
+	}
+	start := time.Now()
+	aw := &auditWriter{ResponseWriter: w}
+	return aw, func(rec DownloadRecord) {
+		rec.Time = start.UTC()
+		rec.Method = r.Method
+		rec.Status = aw.status
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		rec.BytesSent = aw.bytes
+		rec.Duration = time.Since(start)
+		rec.Range = r.Header.Get("Range")
+		rec.ClientIP = tools.clientIP(r)
+		tools.DownloadAudit(rec)
+	}
+}
+
+// authorize runs Tools.DownloadAuthorizer, and answers the request itself when access is refused
+func (tools *Tools) authorize(w http.ResponseWriter, r *http.Request, name string) bool {
+	if tools.DownloadAuthorizer == nil {
+		return true
+	}
+	allowed, status := tools.DownloadAuthorizer(r, name)
+	if allowed {
+		return true
+	}
+	if status < 400 {
+		status = http.StatusForbidden
+	}
+	http.Error(w, http.StatusText(status), status)
+	return false
+}
+
+// clientIP returns the address of the client of r. The first address of X-Forwarded-For is only
+// trusted when TrustProxyHeaders is set.
+func (tools *Tools) clientIP(r *http.Request) string {
+	if tools.TrustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditLog is an append-only sink for download records, written as JSON lines. Its Record method can be
+// used as Tools.DownloadAudit.
+type AuditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	err error
+}
+
+// NewAuditLog opens, or creates, the audit log at path for appending
+func NewAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends rec to the log. Write errors are kept, and returned by Err and Close.
+func (a *AuditLog) Record(rec DownloadRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(rec); err != nil && a.err == nil {
+		a.err = err
+	}
+}
+
+// Err returns the first error met while writing the log
+func (a *AuditLog) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Close closes the log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	return a.err
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// cborEncodeTests come from appendix A of RFC 8949, except for floats, which are never encoded in half precision
+var cborEncodeTests = []struct {
+	value    interface{}
+	expected string
+}{
+	{value: 0, expected: "00"},
+	{value: 23, expected: "17"},
+	{value: 24, expected: "1818"},
+	{value: 100, expected: "1864"},
+	{value: 1000, expected: "1903e8"},
+	{value: 1000000, expected: "1a000f4240"},
+	{value: uint64(1000000000000), expected: "1b000000e8d4a51000"},
+	{value: uint64(18446744073709551615), expected: "1bffffffffffffffff"},
+	{value: -1, expected: "20"},
+	{value: -100, expected: "3863"},
+	{value: -1000, expected: "3903e7"},
+	{value: 1.1, expected: "fb3ff199999999999a"},
+	{value: 100000.0, expected: "fa47c35000"},
+	{value: false, expected: "f4"},
+	{value: true, expected: "f5"},
+	{value: nil, expected: "f6"},
+	{value: []byte{1, 2, 3, 4}, expected: "4401020304"},
+	{value: "", expected: "60"},
+	{value: "IETF", expected: "6449455446"},
+	{value: "ü", expected: "62c3bc"},
+	{value: []int{}, expected: "80"},
+	{value: []interface{}{1, []int{2, 3}, []int{4, 5}}, expected: "8301820203820405"},
+	{value: map[string]interface{}{"a": 1, "b": []int{2, 3}}, expected: "a26161016162820203"},
+	{value: struct {
+		A int    `json:"a"`
+		B string `json:"b,omitempty"`
+		C string `json:"-"`
+	}{A: 1, C: "x"}, expected: "a1616101"},
+}
+
+func TestCBORCodec_Encode(t *testing.T) {
+	for _, e := range cborEncodeTests {
+		var out bytes.Buffer
+		if err := (CBORCodec{}).Encode(&out, e.value); err != nil {
+			t.Errorf("%v: %s", e.value, err)
+			continue
+		}
+		if got := hex.EncodeToString(out.Bytes()); got != e.expected {
+			t.Errorf("%v: expected %s, got %s", e.value, e.expected, got)
+		}
+	}
+}
+
+var cborDecodeTests = []struct {
+	data     string
+	expected interface{}
+}{
+	{data: "0a", expected: int64(10)},
+	{data: "1bffffffffffffffff", expected: uint64(18446744073709551615)},
+	{data: "29", expected: int64(-10)},
+	{data: "f93c00", expected: 1.0},
+	{data: "f97bff", expected: 65504.0},
+	{data: "f90001", expected: 5.960464477539063e-8},
+	{data: "f9fc00", expected: math.Inf(-1)},
+	{data: "fa47c35000", expected: 100000.0},
+	{data: "f7", expected: nil},
+	{data: "c074323031332d30332d32315432303a30343a30305a", expected: "2013-03-21T20:04:00Z"},
+	{data: "5f42010243030405ff", expected: []byte{1, 2, 3, 4, 5}},
+	{data: "7f657374726561646d696e67ff", expected: "streaming"},
+	{data: "9f018202039f0405ffff", expected: []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+	{data: "bf61610161629f0203ffff", expected: map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+}
+
+func TestCBORCodec_Decode(t *testing.T) {
+	for _, e := range cborDecodeTests {
+		data, _ := hex.DecodeString(e.data)
+		var got interface{}
+		if err := (CBORCodec{}).Decode(bytes.NewReader(data), &got); err != nil {
+			t.Errorf("%s: %s", e.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %#v, got %#v", e.data, e.expected, got)
+		}
+	}
+}
+
+func TestCBORCodec_DecodeMalformed(t *testing.T) {
+	malformed := []string{
+		"",                   // empty
+		"18",                 // missing argument
+		"62c3",               // truncated string
+		"1c",                 // reserved additional information
+		"ff",                 // break outside of indefinite length data
+		"5f6161ff",           // text chunk in a byte string
+		"9f01",               // unterminated array
+		"0101",               // trailing data
+		"5bffffffffffffffff", // forged length
+	}
+	for _, m := range malformed {
+		data, _ := hex.DecodeString(m)
+		var got interface{}
+		if err := (CBORCodec{}).Decode(bytes.NewReader(data), &got); err == nil {
+			t.Errorf("%s: expected an error, got %#v", m, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOrderLine struct {
+	SKU      string  `json:"sku" xml:"sku"`
+	Quantity uint16  `json:"quantity" xml:"quantity"`
+	Price    float64 `json:"price" xml:"price"`
+}
+
+type testOrder struct {
+	XMLName  struct{}        `json:"-" xml:"order"`
+	ID       int64           `json:"id" xml:"id"`
+	Customer string          `json:"customer" xml:"customer"`
+	Paid     bool            `json:"paid" xml:"paid"`
+	Lines    []testOrderLine `json:"lines" xml:"line"`
+	Note     *string         `json:"note,omitempty" xml:"note,omitempty"`
+	Placed   time.Time       `json:"placed" xml:"placed"`
+	Blob     []byte          `json:"blob,omitempty" xml:"-"`
+}
+
+func newTestOrder() testOrder {
+	note := "leave at the door"
+	return testOrder{
+		ID:       -42,
+		Customer: "Jack",
+		Paid:     true,
+		Lines:    []testOrderLine{{SKU: "pen", Quantity: 3, Price: 1.25}, {SKU: "ink", Quantity: 300, Price: 10.1}},
+		Note:     &note,
+		Placed:   time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+	}
+}
+
+var writeResponseTests = []struct {
+	accept      string
+	contentType string
+}{
+	{accept: "", contentType: "application/json"},
+	{accept: "application/xml", contentType: "application/xml"},
+	{accept: "application/json;q=0.2, application/cbor", contentType: "application/cbor"},
+	{accept: "application/vnd.msgpack", contentType: "application/msgpack"},
+	{accept: "text/html", contentType: "application/json"},
+}
+
+func TestTools_WriteResponse_ReadBody(t *testing.T) {
+	var testTools Tools
+	order := newTestOrder()
+	for _, e := range writeResponseTests {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", e.accept)
+		if err := testTools.WriteResponse(rr, r, http.StatusCreated, order, http.Header{"X-Order": []string{"42"}}); err != nil {
+			t.Fatalf("%s: %s", e.accept, err)
+		}
+		if rr.Code != http.StatusCreated || rr.Header().Get("X-Order") != "42" || rr.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s: unexpected response %d %v", e.accept, rr.Code, rr.Header())
+		}
+		if rr.Header().Get("Content-Type") != e.contentType {
+			t.Errorf("%s: expected %s, got %s", e.accept, e.contentType, rr.Header().Get("Content-Type"))
+			continue
+		}
+
+		// what was written reads back the same
+		r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(rr.Body.Bytes()))
+		r.Header.Set("Content-Type", e.contentType+"; charset=utf-8")
+		var decoded testOrder
+		if err := testTools.ReadBody(httptest.NewRecorder(), r, &decoded); err != nil {
+			t.Errorf("%s: %s", e.contentType, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, order) {
+			t.Errorf("%s: expected %+v, got %+v", e.contentType, order, decoded)
+		}
+	}
+}
+
+func TestTools_ReadBody_Generic(t *testing.T) {
+	testTools := Tools{AllowUnknownFields: true}
+	var out bytes.Buffer
+	_ = (CBORCodec{}).Encode(&out, map[string]interface{}{"n": 1, "list": []string{"a"}, "nested": map[int]bool{1: true}})
+	r := httptest.NewRequest(http.MethodPost, "/", &out)
+	r.Header.Set("Content-Type", "application/cbor")
+	var decoded interface{}
+	if err := testTools.ReadBody(httptest.NewRecorder(), r, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"n": int64(1), "list": []interface{}{"a"}, "nested": map[string]interface{}{"1": true}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("expected %#v, got %#v", expected, decoded)
+	}
+}
+
+func encodeWith(codec Codec, v interface{}) string {
+	var out bytes.Buffer
+	_ = codec.Encode(&out, v)
+	return out.String()
+}
+
+var readBodyTests = []struct {
+	name        string
+	contentType string
+	body        string
+	maxSize     int
+	allowExtra  bool
+	expected    string
+}{
+	{name: "json", contentType: "application/json", body: `{"foo":"bar"}`},
+	{name: "json without content type", body: `{"foo":"bar"}`},
+	{name: "json suffix", contentType: "application/vnd.api+json", body: `{"foo":"bar"}`},
+	{name: "xml", contentType: "text/xml", body: `<data><foo>bar</foo></data>`},
+	{name: "cbor", contentType: "application/cbor", body: encodeWith(CBORCodec{}, map[string]string{"foo": "bar"})},
+	{name: "msgpack", contentType: "application/x-msgpack", body: encodeWith(MessagePackCodec{}, map[string]string{"foo": "bar"})},
+	{name: "unsupported", contentType: "text/csv", body: "foo\nbar", expected: `unsupported content type "text/csv"`},
+	{name: "invalid content type", contentType: "application/", body: `{}`, expected: `unsupported content type "application/"`},
+	{name: "empty cbor", contentType: "application/cbor", body: "", expected: "body must not be empty"},
+	{name: "truncated msgpack", contentType: "application/msgpack", body: "\x81\xa3foo", expected: "body contains badly-formed MessagePack"},
+	{name: "two cbor values", contentType: "application/cbor", body: "\x01\x02", expected: "body must contain only one CBOR value"},
+	{name: "cbor type", contentType: "application/cbor", body: encodeWith(CBORCodec{}, map[string]int{"foo": 1}), expected: `body contains incorrect CBOR type for field "foo"`},
+	{name: "cbor top-level type", contentType: "application/cbor", body: encodeWith(CBORCodec{}, 1), expected: "body contains incorrect CBOR type"},
+	{name: "msgpack unknown key", contentType: "application/msgpack", body: encodeWith(MessagePackCodec{}, map[string]string{"alpha": "beta"}), expected: `body contains unknown key "alpha"`},
+	{name: "msgpack unknown key allowed", contentType: "application/msgpack", body: encodeWith(MessagePackCodec{}, map[string]string{"alpha": "beta"}), allowExtra: true},
+	{name: "json unknown key", contentType: "application/json", body: `{"alpha":"beta"}`, expected: `body contains unknown key "alpha"`},
+	{name: "cbor too large", contentType: "application/cbor", body: encodeWith(CBORCodec{}, map[string]string{"foo": strings.Repeat("x", 100)}), maxSize: 50, expected: "body must not be larger than 50 bytes"},
+	{name: "xml syntax", contentType: "application/xml", body: `<data><foo>bar</data>`, expected: "body contains badly-formed XML (at line 1)"},
+	{name: "two xml values", contentType: "application/xml", body: `<data></data><data></data>`, expected: "body must contain only one XML value"},
+}
+
+func TestTools_ReadBody(t *testing.T) {
+	for _, e := range readBodyTests {
+		testTools := Tools{MaxJSONSize: e.maxSize, AllowUnknownFields: e.allowExtra}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(e.body))
+		if e.contentType != "" {
+			r.Header.Set("Content-Type", e.contentType)
+		}
+		var decoded struct {
+			Foo string `json:"foo" xml:"foo"`
+		}
+		err := testTools.ReadBody(httptest.NewRecorder(), r, &decoded)
+		switch {
+		case e.expected == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		case e.expected == "" && decoded.Foo != "bar" && !e.allowExtra:
+			t.Errorf("%s: body was not decoded: %+v", e.name, decoded)
+		case e.expected != "" && (err == nil || err.Error() != e.expected):
+			t.Errorf("%s: expected error %q, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestTools_ReadBody_UnsupportedMediaType(t *testing.T) {
+	testTools := Tools{Codecs: []Codec{CBORCodec{}}}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json")
+	var decoded interface{}
+	err := testTools.ReadBody(httptest.NewRecorder(), r, &decoded)
+	var unsupported *UnsupportedMediaTypeError
+	if !errors.As(err, &unsupported) || !reflect.DeepEqual(unsupported.Supported, []string{"application/cbor"}) {
+		t.Errorf("expected an UnsupportedMediaTypeError, got %v", err)
+	}
+}
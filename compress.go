@@ -0,0 +1,257 @@
+package toolkit
+
+import (
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultCompressResponseMinSize is the size from which Compress compresses responses when
+// Tools.CompressResponseMinSize is not set
+const defaultCompressResponseMinSize = 1024
+
+// responseEncodings are the content codings responses are compressed with, in order of preference
+var responseEncodings = []string{"gzip", "deflate"}
+
+// compressor is implemented by gzip.Writer and flate.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var compressorPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	"deflate": {New: func() any {
+		zw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return zw
+	}},
+}
+
+// compressBytes compresses data with the content coding encoding
+func compressBytes(encoding string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	zw := compressorPools[encoding].Get().(compressor)
+	defer compressorPools[encoding].Put(zw)
+	zw.Reset(&out)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// compressBody prepares the complete body of a response written by WriteJSON, ErrorJSON or
+// WriteResponse: it compresses the body if the client accepts it and it is large enough, and sets
+// the Content-Length. The Accept-Encoding header comes from r or, as WriteJSON does not see the
+// request, from the Compress middleware the handler runs under.
+func (tools *Tools) compressBody(w http.ResponseWriter, r *http.Request, body []byte) []byte {
+	var acceptEncoding string
+	minSize := tools.CompressResponseMinSize
+	cw := findCompressWriter(w)
+	switch {
+	case cw != nil && !cw.untouched():
+		// the handler already wrote part of the response, which the middleware handles
+		return body
+	case cw != nil:
+		cw.mode = compressIdentity
+		acceptEncoding, minSize = cw.encoding, cw.minSize
+	case r != nil && minSize > 0:
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if minSize > 0 && len(body) >= minSize && w.Header().Get("Content-Encoding") == "" {
+		if encoding := negotiateEncoding(acceptEncoding, responseEncodings...); encoding != "" {
+			if compressed, err := compressBytes(encoding, body); err == nil {
+				w.Header().Set("Content-Encoding", encoding)
+				body = compressed
+			}
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	return body
+}
+
+// Compress is a middleware that compresses the responses of next with gzip or deflate, as the
+// Accept-Encoding header of the client allows. Responses are held back until they reach
+// CompressResponseMinSize bytes, 1024 by default, and sent as is, with their Content-Length, if they
+// end before. Responses that are already encoded, partial, or of a type that does not compress well
+// are never compressed, and flushing a response that has not reached the threshold yet sends it as is,
+// so that streams are not delayed. Inside the middleware, WriteJSON and ErrorJSON compress their
+// complete body themselves and send its exact Content-Length.
+func (tools *Tools) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), responseEncodings...)
+		if r.Method == http.MethodHead || encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        cmp.Or(tools.CompressResponseMinSize, defaultCompressResponseMinSize),
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Modes of a compressWriter
+const (
+	compressUndecided = iota
+	compressIdentity
+	compressEncoded
+)
+
+// compressWriter buffers the start of a response until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	minSize    int
+	mode       int
+	status     int
+	headerSent bool
+	buf        []byte
+	zw         compressor
+}
+
+// findCompressWriter returns the compressWriter w is, or wraps, if any
+func findCompressWriter(w http.ResponseWriter) *compressWriter {
+	for {
+		switch t := w.(type) {
+		case *compressWriter:
+			return t
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// untouched reports whether nothing was written to cw yet
+func (cw *compressWriter) untouched() bool {
+	return cw.mode == compressUndecided && cw.status == 0 && len(cw.buf) == 0
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	switch {
+	case status < 200 && status != http.StatusSwitchingProtocols:
+		// informational responses go out right away
+		cw.ResponseWriter.WriteHeader(status)
+	case cw.mode == compressIdentity || cw.headerSent:
+		cw.sendHeader(status)
+	case cw.status == 0:
+		cw.status = status
+	}
+}
+
+// sendHeader sends the response header with status, once
+func (cw *compressWriter) sendHeader(status int) {
+	if !cw.headerSent {
+		cw.headerSent = true
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	switch cw.mode {
+	case compressIdentity:
+		cw.sendHeader(cw.status)
+		return cw.ResponseWriter.Write(p)
+	case compressEncoded:
+		return cw.zw.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide chooses whether to compress the response, then sends the header and the buffered start of
+// the body. If final is set, the buffer holds the whole body.
+func (cw *compressWriter) decide(final bool) error {
+	h := cw.Header()
+	contentType := h.Get("Content-Type")
+	if _, set := h["Content-Type"]; !set && len(cw.buf) > 0 {
+		// set the type now, as it cannot be sniffed from the compressed body
+		contentType = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", contentType)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) < cw.minSize || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		cw.status == http.StatusPartialContent || cw.status == http.StatusNoContent ||
+		cw.status == http.StatusNotModified || !compressible(contentType) {
+		cw.mode = compressIdentity
+		if final && h.Get("Content-Length") == "" && cw.status != http.StatusNoContent && cw.status != http.StatusNotModified {
+			h.Set("Content-Length", strconv.Itoa(len(buf)))
+		}
+		cw.sendHeader(cw.status)
+		_, err := cw.ResponseWriter.Write(buf)
+		return err
+	}
+
+	cw.mode = compressEncoded
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		// the compressed bytes differ from those the strong ETag stands for
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.sendHeader(cw.status)
+	cw.zw = compressorPools[cw.encoding].Get().(compressor)
+	cw.zw.Reset(cw.ResponseWriter)
+	_, err := cw.zw.Write(buf)
+	return err
+}
+
+// Flush sends what has been written so far. A response still below the threshold is sent as is.
+func (cw *compressWriter) Flush() {
+	if cw.mode == compressUndecided && cw.status != 0 {
+		_ = cw.decide(false)
+	}
+	if cw.mode == compressEncoded {
+		_ = cw.zw.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close ends the response once the handler returns
+func (cw *compressWriter) close() {
+	switch {
+	case cw.mode == compressUndecided && cw.status != 0:
+		_ = cw.decide(true)
+		if cw.mode == compressEncoded {
+			cw.close()
+		}
+	case cw.mode == compressEncoded:
+		_ = cw.zw.Close()
+		cw.zw.Reset(io.Discard)
+		compressorPools[cw.encoding].Put(cw.zw)
+		cw.zw = nil
+		cw.mode = compressIdentity
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
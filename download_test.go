@@ -0,0 +1,127 @@
+package toolkit
+
+import (
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var contentDispositionTests = []struct {
+	name     string
+	inline   bool
+	display  string
+	expected string
+}{
+	{name: "ascii", display: "puppy.jpg", expected: `attachment; filename="puppy.jpg"`},
+	{name: "inline", inline: true, display: "puppy.jpg", expected: `inline; filename="puppy.jpg"`},
+	{name: "quote", display: `say "hi".txt`, expected: `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`},
+	{name: "header injection", display: "a\r\nSet-Cookie: x=1.txt", expected: `attachment; filename="aSet-Cookie: x=1.txt"; filename*=UTF-8''a%0D%0ASet-Cookie%3A%20x%3D1.txt`},
+	{name: "cyrillic", display: "отчёт.pdf", expected: `attachment; filename="_____.pdf"; filename*=UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.pdf`},
+	{name: "cjk", display: "报告.pdf", expected: `attachment; filename="__.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A.pdf`},
+	{name: "empty", display: "\n", expected: `attachment`},
+}
+
+func TestContentDisposition(t *testing.T) {
+	for _, e := range contentDispositionTests {
+		if got := contentDisposition(e.inline, e.display); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_DownloadStaticFileWithOptions(t *testing.T) {
+	var testTool Tools
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	testTool.DownloadStaticFileWithOptions(w, r, "./testdata", "pic.jpg", DownloadOptions{
+		DisplayName: "щенок.jpg",
+		Inline:      true,
+		ContentType: "application/octet-stream",
+	})
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status %d", res.StatusCode)
+	}
+	if res.Header.Get("Content-Disposition") != `inline; filename="_____.jpg"; filename*=UTF-8''%D1%89%D0%B5%D0%BD%D0%BE%D0%BA.jpg` {
+		t.Errorf("wrong Content-Disposition %s", res.Header.Get("Content-Disposition"))
+	}
+	if res.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("wrong Content-Type %s", res.Header.Get("Content-Type"))
+	}
+}
+
+//go:embed testdata/pic.jpg
+var embeddedFiles embed.FS
+
+// noSeekFS hides the io.Seeker implementation of the files of an fs.FS
+type noSeekFS struct {
+	fsys fs.FS
+}
+
+type noSeekFile struct {
+	fs.File
+}
+
+func (n noSeekFS) Open(name string) (fs.File, error) {
+	f, err := n.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return noSeekFile{f}, nil
+}
+
+func TestTools_DownloadFS(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	streamed := noSeekFS{fstest.MapFS{"reports/q1.csv": {Data: []byte("a,b\n1,2\n"), ModTime: modTime}}}
+	var testTool Tools
+
+	var fsTests = []struct {
+		name          string
+		fsys          fs.FS
+		file          string
+		header        string
+		value         string
+		status        int
+		contentLength string
+		contentType   string
+	}{
+		{name: "embed", fsys: embeddedFiles, file: "testdata/pic.jpg", status: http.StatusOK, contentLength: "98827", contentType: "image/jpeg"},
+		{name: "embed range", fsys: embeddedFiles, file: "testdata/pic.jpg", header: "Range", value: "bytes=0-9", status: http.StatusPartialContent, contentLength: "10", contentType: "image/jpeg"},
+		{name: "embed traversal", fsys: embeddedFiles, file: "../testdata/pic.jpg", status: http.StatusNotFound},
+		{name: "no seek", fsys: streamed, file: "reports/q1.csv", status: http.StatusOK, contentLength: "8", contentType: "text/csv; charset=utf-8"},
+		{name: "no seek ignores range", fsys: streamed, file: "reports/q1.csv", header: "Range", value: "bytes=0-1", status: http.StatusOK, contentLength: "8", contentType: "text/csv; charset=utf-8"},
+		{name: "no seek not modified", fsys: streamed, file: "reports/q1.csv", header: "If-Modified-Since", value: modTime.Format(http.TimeFormat), status: http.StatusNotModified},
+	}
+	for _, e := range fsTests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if e.header != "" {
+			r.Header.Set(e.header, e.value)
+		}
+		testTool.DownloadFS(w, r, e.fsys, e.file, DownloadOptions{DisplayName: "file"})
+		res := w.Result()
+		if res.StatusCode != e.status {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.status, res.StatusCode)
+			continue
+		}
+		if e.status != http.StatusOK && e.status != http.StatusPartialContent {
+			continue
+		}
+		body, _ := io.ReadAll(res.Body)
+		if res.Header.Get("Content-Length") != e.contentLength || strconv.Itoa(len(body)) != e.contentLength {
+			t.Errorf("%s: wrong Content-Length %s for %d bytes", e.name, res.Header.Get("Content-Length"), len(body))
+		}
+		if res.Header.Get("Content-Type") != e.contentType {
+			t.Errorf("%s: wrong Content-Type %s", e.name, res.Header.Get("Content-Type"))
+		}
+		if res.Header.Get("Content-Disposition") != `attachment; filename="file"` {
+			t.Errorf("%s: wrong Content-Disposition %s", e.name, res.Header.Get("Content-Disposition"))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache-Control policies for downloads
+const (
+	// CacheImmutable suits content-addressed file names, whose content never changes
+	CacheImmutable = "public, max-age=31536000, immutable"
+	// CachePrivate lets browsers, but not shared caches, keep the file as long as they revalidate it
+	CachePrivate = "private, no-cache"
+	// CacheNoStore forbids storing sensitive files in any cache
+	CacheNoStore = "no-store"
+)
+
+// maxETagCacheEntries bounds the memory used by the ETag cache; it is emptied when full
+const maxETagCacheEntries = 10000
+
+// etagKey identifies a version of a file: the same name in the same file system, with the same size
+// and modification time, is assumed to have the same content
+type etagKey struct {
+	fsys    any
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+var etagCache = struct {
+	sync.Mutex
+	entries map[etagKey]string
+}{entries: make(map[etagKey]string)}
+
+// contentETag returns a strong ETag derived from the SHA-256 of the file name in fsys. Results are
+// cached as long as the size and modification time of the file do not change; identity identifies
+// fsys in the cache, and must be comparable for caching to happen.
+func contentETag(fsys fs.FS, identity any, name string, info fs.FileInfo, meta *FileMetadata) (string, error) {
+	if meta != nil && meta.Checksum != "" && meta.FileSize == info.Size() {
+		return formatETag(meta.Checksum), nil
+	}
+	cacheable := identity != nil && reflect.ValueOf(identity).Comparable()
+	key := etagKey{fsys: identity, name: name, size: info.Size(), modTime: info.ModTime()}
+	if cacheable {
+		etagCache.Lock()
+		etag, ok := etagCache.entries[key]
+		etagCache.Unlock()
+		if ok {
+			return etag, nil
+		}
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer func(f fs.File) {
+		_ = f.Close()
+	}(f)
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := formatETag(hex.EncodeToString(h.Sum(nil)))
+
+	if cacheable {
+		etagCache.Lock()
+		if len(etagCache.entries) >= maxETagCacheEntries {
+			etagCache.entries = make(map[etagKey]string)
+		}
+		etagCache.entries[key] = etag
+		etagCache.Unlock()
+	}
+	return etag, nil
+}
+
+// formatETag turns a hex encoded SHA-256 into a strong ETag
+func formatETag(checksum string) string {
+	if len(checksum) > 32 {
+		checksum = checksum[:32]
+	}
+	return `"` + checksum + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the weak comparison
+func etagMatches(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// absPath returns the absolute form of dir, used to identify directories opened anew for every download
+func absPath(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
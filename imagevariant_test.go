@@ -0,0 +1,129 @@
+package toolkit
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+var imageVariantTests = []struct {
+	name          string
+	query         string
+	expectStatus  int
+	contentType   string
+	width, height int
+}{
+	{name: "contain", query: "w=100&h=100", expectStatus: http.StatusOK, contentType: "image/jpeg"},
+	{name: "cover", query: "w=100&h=100&fit=cover", expectStatus: http.StatusOK, contentType: "image/jpeg", width: 100, height: 100},
+	{name: "fill", query: "w=100&h=50&fit=fill&fmt=png", expectStatus: http.StatusOK, contentType: "image/png", width: 100, height: 50},
+	{name: "auto height", query: "w=100&q=50", expectStatus: http.StatusOK, contentType: "image/jpeg", width: 100},
+	{name: "size not allowed", query: "w=101&h=100", expectStatus: http.StatusBadRequest},
+	{name: "bad fit", query: "w=100&h=100&fit=zoom", expectStatus: http.StatusBadRequest},
+	{name: "bad quality", query: "w=100&q=0", expectStatus: http.StatusBadRequest},
+	{name: "bad format", query: "w=100&fmt=gif", expectStatus: http.StatusBadRequest},
+}
+
+func TestTools_ServeImageVariant(t *testing.T) {
+	testTools := Tools{
+		ImageSizes:    []ImageSize{{Width: 100, Height: 100}, {Width: 100, Height: 50}, {Width: 100}},
+		ImageCacheDir: t.TempDir(),
+	}
+	for _, e := range imageVariantTests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/?"+e.query, nil)
+		testTools.ServeImageVariant(w, r, "./testdata", "pic.jpg")
+		res := w.Result()
+		if res.StatusCode != e.expectStatus {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.expectStatus, res.StatusCode)
+			continue
+		}
+		if e.expectStatus != http.StatusOK {
+			continue
+		}
+		if res.Header.Get("Content-Type") != e.contentType {
+			t.Errorf("%s: wrong Content-Type %s", e.name, res.Header.Get("Content-Type"))
+		}
+		if res.Header.Get("ETag") == "" {
+			t.Errorf("%s: no ETag", e.name)
+		}
+		config, _, err := image.DecodeConfig(res.Body)
+		if err != nil {
+			t.Errorf("%s: cannot decode variant: %s", e.name, err)
+			continue
+		}
+		if config.Width > 100 || config.Height > 100 {
+			t.Errorf("%s: variant of %dx%d is larger than requested", e.name, config.Width, config.Height)
+		}
+		if (e.width != 0 && config.Width != e.width) || (e.height != 0 && config.Height != e.height) {
+			t.Errorf("%s: expected %dx%d, got %dx%d", e.name, e.width, e.height, config.Width, config.Height)
+		}
+	}
+
+	entries, err := os.ReadDir(testTools.ImageCacheDir)
+	if err != nil || len(entries) != 4 {
+		t.Errorf("expected 4 cached variants, got %d (%v)", len(entries), err)
+	}
+
+	// a second request is served from the cache and can be revalidated
+	w := httptest.NewRecorder()
+	testTools.ServeImageVariant(w, httptest.NewRequest(http.MethodGet, "/?w=100&h=100", nil), "./testdata", "pic.jpg")
+	etag := w.Result().Header.Get("ETag")
+	r := httptest.NewRequest(http.MethodGet, "/?w=100&h=100", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	testTools.ServeImageVariant(w, r, "./testdata", "pic.jpg")
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", w.Code)
+	}
+
+	// the source is served as is without size or format
+	w = httptest.NewRecorder()
+	testTools.ServeImageVariant(w, httptest.NewRequest(http.MethodGet, "/", nil), "./testdata", "pic.jpg")
+	if w.Code != http.StatusOK || w.Result().Header.Get("Content-Disposition") != `inline; filename="pic.jpg"` {
+		t.Errorf("expected the original image, got %d %s", w.Code, w.Result().Header.Get("Content-Disposition"))
+	}
+
+	w = httptest.NewRecorder()
+	testTools.ServeImageVariant(w, httptest.NewRequest(http.MethodGet, "/?w=100&h=100", nil), "./testdata", "../tools.go")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 outside of the directory, got %d", w.Code)
+	}
+}
+
+func TestResizeBox(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	for x := 0; x < 2; x++ {
+		for y := 0; y < 2; y++ {
+			o := src.PixOffset(x, y)
+			src.Pix[o], src.Pix[o+1], src.Pix[o+2] = 0, 0, 0
+		}
+	}
+	dst := resizeBox(src, 2, 1)
+	if dst.Pix[0] != 0 || dst.Pix[4] != 255 || dst.Pix[3] != 255 {
+		t.Errorf("unexpected pixels %v", dst.Pix)
+	}
+}
+
+func TestFitImage_Thin(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+	}{
+		{name: "one pixel tall", width: 10, height: 1},
+		{name: "one pixel wide", width: 1, height: 10},
+	}
+	for _, e := range tests {
+		src := image.NewRGBA(image.Rect(0, 0, e.width, e.height))
+		for _, size := range [][2]int{{100, 200}, {200, 100}} {
+			dst := fitImage(src, size[0], size[1], FitCover, true)
+			if dst.Bounds().Dx() != size[0] || dst.Bounds().Dy() != size[1] {
+				t.Errorf("%s: expected %dx%d, got %v", e.name, size[0], size[1], dst.Bounds())
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvictionPolicy selects which files a Janitor removes first when the upload directory is over its size limit
+type EvictionPolicy int
+
+const (
+	// EvictOldest removes the files with the oldest modification time first
+	EvictOldest EvictionPolicy = iota
+	// EvictLeastRecentlyUsed removes the files with the oldest access time first. Access times are only
+	// as accurate as the file system keeps them, and fall back to modification times where unavailable.
+	EvictLeastRecentlyUsed
+)
+
+// Reasons reported in a JanitorRemoval
+const (
+	RemovedExpired  = "expired"
+	RemovedOverSize = "over size"
+	RemovedOrphan   = "orphaned metadata"
+)
+
+// JanitorRemoval describes a file removed by a Janitor
+type JanitorRemoval struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Reason  string
+}
+
+// Janitor enforces a retention policy on an upload directory: it deletes files older than TTL and,
+// when the directory holds more than MaxSize bytes, evicts files until it fits. Sub-directories are
+// walked recursively, metadata sidecars are removed together with their file, and pinned files are
+// never removed. A Janitor that is not built by NewJanitor releases no quota and knows no quarantine.
+type Janitor struct {
+	Dir      string
+	TTL      time.Duration
+	MaxSize  int64
+	Eviction EvictionPolicy
+	// Interval is the time between two sweeps when running in the background; it defaults to an hour
+	Interval time.Duration
+	// IsPinned, if set, is consulted in addition to the Pinned flag of the metadata sidecar
+	IsPinned func(path string, meta *FileMetadata) bool
+	// OnRemove, if set, is called for every file removed
+	OnRemove func(removal JanitorRemoval)
+
+	tools *Tools
+}
+
+// janitorFile is a candidate for removal found while walking the upload directory
+type janitorFile struct {
+	path     string
+	size     int64
+	modTime  time.Time
+	lastUsed time.Time
+}
+
+// NewJanitor returns a Janitor for dir. Files it removes are deleted with DeleteUploadedFile, so
+// that quota usage is released.
+func (tools *Tools) NewJanitor(dir string) *Janitor {
+	return &Janitor{Dir: dir, Interval: time.Hour, tools: tools}
+}
+
+// toolkit returns the Tools the janitor was built by, or a zero Tools
+func (j *Janitor) toolkit() *Tools {
+	if j.tools == nil {
+		return &Tools{}
+	}
+	return j.tools
+}
+
+// Run sweeps the directory immediately and then every Interval, until ctx is done
+func (j *Janitor) Run(ctx context.Context) error {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := j.Sweep(ctx); err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep applies the retention policy once
+func (j *Janitor) Sweep(ctx context.Context) error {
+	var files []janitorFile
+	var total int64
+	// emptied holds the directories the sweep removed files from
+	emptied := make(map[string]bool)
+	now := time.Now()
+	err := filepath.WalkDir(j.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if path != j.Dir && (strings.HasPrefix(name, ".") || j.isQuarantine(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			// already removed together with the file it describes
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isMetadataFile(name) {
+			if _, err = os.Stat(strings.TrimSuffix(path, MetadataSuffix)); os.IsNotExist(err) {
+				return j.remove(janitorFile{path: path, size: info.Size(), modTime: info.ModTime()}, RemovedOrphan, emptied)
+			}
+			return nil
+		}
+		if j.pinned(path) {
+			return nil
+		}
+		f := janitorFile{path: path, size: info.Size(), modTime: info.ModTime(), lastUsed: info.ModTime()}
+		if j.Eviction == EvictLeastRecentlyUsed {
+			f.lastUsed = accessTime(info)
+		}
+		if j.TTL > 0 && now.Sub(f.modTime) > j.TTL {
+			return j.remove(f, RemovedExpired, emptied)
+		}
+		files = append(files, f)
+		total += f.size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if j.MaxSize > 0 && total > j.MaxSize {
+		sort.Slice(files, func(a, b int) bool {
+			return files[a].lastUsed.Before(files[b].lastUsed)
+		})
+		for _, f := range files {
+			if total <= j.MaxSize {
+				break
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if err = j.remove(f, RemovedOverSize, emptied); err != nil {
+				return err
+			}
+			total -= f.size
+		}
+	}
+	j.removeEmptyDirs(emptied)
+	return nil
+}
+
+// remove deletes a file, and its sidecar, and reports it. The directory of the file is added to emptied.
+func (j *Janitor) remove(f janitorFile, reason string, emptied map[string]bool) error {
+	var err error
+	if reason == RemovedOrphan {
+		err = os.Remove(f.path)
+	} else {
+		err = j.toolkit().DeleteUploadedFile("", f.path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	emptied[filepath.Dir(f.path)] = true
+	if j.OnRemove != nil {
+		j.OnRemove(JanitorRemoval{Path: f.path, Size: f.size, ModTime: f.modTime, Reason: reason})
+	}
+	return nil
+}
+
+// pinned reports whether the file at path must be kept
+func (j *Janitor) pinned(path string) bool {
+	meta, err := j.toolkit().ReadMetadata(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		meta = nil
+	}
+	if meta != nil && meta.Pinned {
+		return true
+	}
+	return j.IsPinned != nil && j.IsPinned(path, meta)
+}
+
+// isQuarantine reports whether dir is the quarantine directory, whose files are not yet owned by the upload directory
+func (j *Janitor) isQuarantine(dir string) bool {
+	quarantine := j.toolkit().Quarantine
+	if quarantine == nil {
+		return false
+	}
+	a, errA := filepath.Abs(dir)
+	b, errB := filepath.Abs(quarantine.Dir)
+	return errA == nil && errB == nil && a == b
+}
+
+// removeEmptyDirs deletes the sub-directories that a sweep left empty, deepest first: the directories
+// it removed files from, and their parents up to the janitor's directory. Directories that were
+// already empty, and those the sweep skipped, such as the quarantine, are kept.
+func (j *Janitor) removeEmptyDirs(emptied map[string]bool) {
+	dirs := make([]string, 0, len(emptied))
+	for dir := range emptied {
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	sort.Slice(dirs, func(a, b int) bool {
+		return len(dirs[a]) > len(dirs[b])
+	})
+	for _, dir := range dirs {
+		for {
+			rel, err := filepath.Rel(j.Dir, dir)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				break
+			}
+			// os.Remove refuses to delete a directory that is not empty
+			if os.Remove(dir) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+}
+
+// SetPinned marks the stored file name in dir as pinned, or unpinned, in its metadata sidecar,
+// creating a minimal sidecar if the file does not have one
+func (tools *Tools) SetPinned(dir, name string, pinned bool) error {
+	meta, err := tools.ReadMetadata(dir, name)
+	if os.IsNotExist(err) {
+		var info os.FileInfo
+		if info, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		meta = &FileMetadata{UploadedFile: UploadedFile{NewFileName: name, FileSize: info.Size()}, UploadedAt: info.ModTime().UTC()}
+	} else if err != nil {
+		return err
+	}
+	meta.Pinned = pinned
+	return writeMetadata(dir, meta)
+}
@@ -0,0 +1,151 @@
+package toolkit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeAged creates a file of the given size whose access and modification times are age in the past
+func writeAged(t *testing.T, path string, size int, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now().Add(-age)
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJanitor_Sweep(t *testing.T) {
+	dir := t.TempDir()
+	writeAged(t, filepath.Join(dir, "expired.txt"), 10, 48*time.Hour)
+	writeAged(t, filepath.Join(dir, "ab", "cd", "sharded-expired.txt"), 10, 48*time.Hour)
+	writeAged(t, filepath.Join(dir, "pinned.txt"), 10, 48*time.Hour)
+	writeAged(t, filepath.Join(dir, "old.txt"), 40, 3*time.Hour)
+	writeAged(t, filepath.Join(dir, "ab", "older.txt"), 40, 4*time.Hour)
+	writeAged(t, filepath.Join(dir, "new.txt"), 40, time.Hour)
+	writeAged(t, filepath.Join(dir, "gone.txt"+MetadataSuffix), 2, time.Hour)
+
+	var testTools Tools
+	if err := testTools.SetPinned(dir, "pinned.txt", true); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []string
+	janitor := testTools.NewJanitor(dir)
+	janitor.TTL = 24 * time.Hour
+	janitor.MaxSize = 100
+	janitor.Eviction = EvictLeastRecentlyUsed
+	janitor.OnRemove = func(removal JanitorRemoval) {
+		rel, _ := filepath.Rel(dir, removal.Path)
+		removed = append(removed, rel+" "+removal.Reason)
+	}
+	if err := janitor.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(removed)
+	expected := []string{
+		filepath.Join("ab", "cd", "sharded-expired.txt") + " " + RemovedExpired,
+		filepath.Join("ab", "older.txt") + " " + RemovedOverSize,
+		"expired.txt " + RemovedExpired,
+		"gone.txt" + MetadataSuffix + " " + RemovedOrphan,
+	}
+	if len(removed) != len(expected) {
+		t.Fatalf("expected %v to be removed, got %v", expected, removed)
+	}
+	for i := range expected {
+		if removed[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], removed[i])
+		}
+	}
+	for _, kept := range []string{"pinned.txt", "pinned.txt" + MetadataSuffix, "old.txt", "new.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+			t.Errorf("expected %s to be kept: %s", kept, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ab", "cd")); !os.IsNotExist(err) {
+		t.Error("expected empty shard directory to be removed")
+	}
+}
+
+func TestJanitor_Sweep_Literal(t *testing.T) {
+	dir := t.TempDir()
+	writeAged(t, filepath.Join(dir, "expired.txt"), 10, 48*time.Hour)
+	writeAged(t, filepath.Join(dir, "ab", "new.txt"), 10, time.Hour)
+
+	janitor := &Janitor{Dir: dir, TTL: 24 * time.Hour}
+	if err := janitor.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired.txt")); !os.IsNotExist(err) {
+		t.Error("expected the expired file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ab", "new.txt")); err != nil {
+		t.Errorf("expected the new file to be kept: %s", err)
+	}
+}
+
+func TestJanitor_Sweep_KeepsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	quarantine, err := NewQuarantine(filepath.Join(dir, "quarantine"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = quarantine.Close(context.Background()) }()
+	for _, empty := range []string{".cache", "empty", filepath.Join("ab", "kept")} {
+		if err := os.MkdirAll(filepath.Join(dir, empty), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeAged(t, filepath.Join(dir, "ab", "cd", "expired.txt"), 10, 48*time.Hour)
+	writeAged(t, filepath.Join(dir, "ef", "gh", "expired.txt"), 10, 48*time.Hour)
+
+	testTools := Tools{Quarantine: quarantine}
+	janitor := testTools.NewJanitor(dir)
+	janitor.TTL = 24 * time.Hour
+	if err := janitor.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the directories the sweep emptied go, with the parents they leave empty
+	for _, kept := range []string{"quarantine", ".cache", "empty", filepath.Join("ab", "kept")} {
+		if _, err := os.Stat(filepath.Join(dir, kept)); err != nil {
+			t.Errorf("expected %s to be kept: %s", kept, err)
+		}
+	}
+	for _, gone := range []string{filepath.Join("ab", "cd"), "ef"} {
+		if _, err := os.Stat(filepath.Join(dir, gone)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", gone)
+		}
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected the upload directory to be kept: %s", err)
+	}
+}
+
+func TestJanitor_Run(t *testing.T) {
+	dir := t.TempDir()
+	writeAged(t, filepath.Join(dir, "expired.txt"), 10, time.Hour)
+
+	var testTools Tools
+	janitor := testTools.NewJanitor(dir)
+	janitor.TTL = time.Minute
+	janitor.Interval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := janitor.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected Run to stop with the context, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "expired.txt")); !os.IsNotExist(err) {
+		t.Error("expected expired file to be removed")
+	}
+}
@@ -0,0 +1,123 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MetadataSuffix is appended to the name of a stored file to get the name of its metadata sidecar
+const MetadataSuffix = ".meta.json"
+
+// FileMetadata is the content of the sidecar written next to an uploaded file when
+// Tools.WriteMetadata is set
+type FileMetadata struct {
+	UploadedFile
+	UploadedAt time.Time         `json:"uploadedAt"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Pinned files are never removed by a Janitor
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// metadataSidecar is the JSON form of FileMetadata in sidecar files, which names its members in camel
+// case without changing the JSON form of UploadedFile
+type metadataSidecar struct {
+	ID               string            `json:"id,omitempty"`
+	NewFileName      string            `json:"newFileName"`
+	OriginalFileName string            `json:"originalFileName"`
+	FileSize         int64             `json:"fileSize"`
+	Owner            string            `json:"owner,omitempty"`
+	ContentType      string            `json:"contentType"`
+	Checksum         string            `json:"checksum"`
+	UploadedAt       time.Time         `json:"uploadedAt"`
+	Attributes       map[string]string `json:"attributes,omitempty"`
+	Pinned           bool              `json:"pinned,omitempty"`
+}
+
+// MarshalJSON encodes the metadata as it is stored in sidecar files
+func (m FileMetadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metadataSidecar{
+		ID:               m.ID,
+		NewFileName:      m.NewFileName,
+		OriginalFileName: m.OriginalFileName,
+		FileSize:         m.FileSize,
+		Owner:            m.Owner,
+		ContentType:      m.ContentType,
+		Checksum:         m.Checksum,
+		UploadedAt:       m.UploadedAt,
+		Attributes:       m.Attributes,
+		Pinned:           m.Pinned,
+	})
+}
+
+// UnmarshalJSON decodes the metadata as it is stored in sidecar files
+func (m *FileMetadata) UnmarshalJSON(data []byte) error {
+	var sidecar metadataSidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return err
+	}
+	*m = FileMetadata{
+		UploadedFile: UploadedFile{
+			ID:               sidecar.ID,
+			NewFileName:      sidecar.NewFileName,
+			OriginalFileName: sidecar.OriginalFileName,
+			FileSize:         sidecar.FileSize,
+			Owner:            sidecar.Owner,
+			ContentType:      sidecar.ContentType,
+			Checksum:         sidecar.Checksum,
+		},
+		UploadedAt: sidecar.UploadedAt,
+		Attributes: sidecar.Attributes,
+		Pinned:     sidecar.Pinned,
+	}
+	return nil
+}
+
+// ReadMetadata reads the sidecar of the stored file name in dir
+func (tools *Tools) ReadMetadata(dir, name string) (*FileMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name+MetadataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var meta FileMetadata
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("error reading metadata of %s: %w", name, err)
+	}
+	return &meta, nil
+}
+
+// writeMetadata atomically writes the sidecar of the stored file described by meta in dir
+func writeMetadata(dir string, meta *FileMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, meta.NewFileName+MetadataSuffix), data)
+}
+
+// isMetadataFile reports whether name is a metadata sidecar
+func isMetadataFile(name string) bool {
+	return strings.HasSuffix(name, MetadataSuffix)
+}
+
+// checksumFile returns the hex encoded SHA-256 of the file at path
+func checksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
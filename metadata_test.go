@@ -0,0 +1,75 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadFiles_Metadata(t *testing.T) {
+	uploadDir := t.TempDir()
+	testTools := Tools{
+		WriteMetadata: true,
+		MetadataAttributes: func(r *http.Request, file *UploadedFile) map[string]string {
+			return map[string]string{"uploader": r.Header.Get("X-User")}
+		},
+	}
+	request := newUploadRequest(t, "Quarterly Report.txt", []byte("foo"))
+	request.Header.Set("X-User", "alice")
+	uploaded, err := testTools.UploadOneFile(request, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := testTools.ReadMetadata(uploadDir, uploaded.NewFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.OriginalFileName != "Quarterly Report.txt" || meta.FileSize != 3 {
+		t.Errorf("wrong metadata: %+v", meta)
+	}
+	if meta.Checksum != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Errorf("wrong checksum %s", meta.Checksum)
+	}
+	if meta.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("wrong content type %s", meta.ContentType)
+	}
+	if meta.Attributes["uploader"] != "alice" || meta.UploadedAt.IsZero() {
+		t.Errorf("missing attributes or upload time: %+v", meta)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	testTools.DownloadStaticFile(w, r, uploadDir, uploaded.NewFileName, "")
+	res := w.Result()
+	if res.Header.Get("Content-Disposition") != `attachment; filename="Quarterly Report.txt"` {
+		t.Errorf("wrong Content-Disposition %s", res.Header.Get("Content-Disposition"))
+	}
+	if res.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("wrong Content-Type %s", res.Header.Get("Content-Type"))
+	}
+
+	// the sidecar names its members in camel case, while UploadedFile keeps its JSON form
+	sidecar, err := os.ReadFile(filepath.Join(uploadDir, uploaded.NewFileName+MetadataSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sidecar), `"originalFileName": "Quarterly Report.txt"`) {
+		t.Errorf("unexpected sidecar %s", sidecar)
+	}
+	out, _ := json.Marshal(uploaded)
+	if !strings.Contains(string(out), `"NewFileName":"`+uploaded.NewFileName+`"`) {
+		t.Errorf("unexpected JSON form of UploadedFile %s", out)
+	}
+
+	if err = testTools.DeleteUploadedFile("", filepath.Join(uploadDir, uploaded.NewFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(uploadDir, uploaded.NewFileName+MetadataSuffix)); !os.IsNotExist(err) {
+		t.Error("expected sidecar to be deleted with the file")
+	}
+}
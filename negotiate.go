@@ -0,0 +1,113 @@
+package toolkit
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// acceptItem is one element of an Accept style header: a value, its quality and its other parameters
+type acceptItem struct {
+	value  string
+	q      float64
+	params map[string]string
+}
+
+// parseAccept splits an Accept style header, such as Accept or Accept-Encoding, into its elements.
+// Elements without a q parameter have a quality of 1; those with an invalid one are ignored.
+func parseAccept(header string) []acceptItem {
+	var items []acceptItem
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		item := acceptItem{value: strings.ToLower(strings.TrimSpace(fields[0])), q: 1}
+		if item.value == "" {
+			continue
+		}
+		valid := true
+		for _, param := range fields[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			key, value = strings.ToLower(strings.TrimSpace(key)), strings.Trim(strings.TrimSpace(value), `"`)
+			if key != "q" {
+				if item.params == nil {
+					item.params = make(map[string]string)
+				}
+				item.params[key] = value
+				continue
+			}
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			item.q = q
+		}
+		if valid {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// negotiateEncoding returns the content coding, among offered, that the client prefers according to
+// its Accept-Encoding header, or an empty string if it accepts none of them. Ties go to the coding
+// offered first.
+func negotiateEncoding(header string, offered ...string) string {
+	items := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		q, wildcard := -1.0, -1.0
+		for _, item := range items {
+			switch item.value {
+			case coding:
+				q = item.q
+			case "*":
+				wildcard = item.q
+			}
+		}
+		if q < 0 {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// negotiateMediaType returns the media type, among offered, that the client prefers according to its
+// Accept header, or an empty string if it accepts none of them. Each offered type takes the quality of
+// the most specific range that matches it; ties go to the type offered first. Without an Accept header,
+// the first type is chosen.
+func negotiateMediaType(header string, offered ...string) string {
+	if strings.TrimSpace(header) == "" {
+		if len(offered) == 0 {
+			return ""
+		}
+		return offered[0]
+	}
+	items := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, mediaType := range offered {
+		mainType, _, _ := strings.Cut(mediaType, "/")
+		q, specificity := 0.0, -1
+		for _, item := range items {
+			value := cmp.Or(mediaTypeAliases[item.value], item.value)
+			s := -1
+			switch value {
+			case mediaType:
+				s = 2
+			case mainType + "/*":
+				s = 1
+			case "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = item.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,53 @@
+package toolkit
+
+import "testing"
+
+var negotiateEncodingTests = []struct {
+	name     string
+	header   string
+	offered  []string
+	expected string
+}{
+	{name: "empty", header: "", offered: []string{"gzip"}, expected: ""},
+	{name: "single", header: "gzip", offered: []string{"br", "gzip"}, expected: "gzip"},
+	{name: "server order on ties", header: "gzip, br", offered: []string{"br", "gzip"}, expected: "br"},
+	{name: "quality", header: "br;q=0.5, gzip", offered: []string{"br", "gzip"}, expected: "gzip"},
+	{name: "refused", header: "br;q=0, gzip;q=0", offered: []string{"br", "gzip"}, expected: ""},
+	{name: "wildcard", header: "*", offered: []string{"zstd"}, expected: "zstd"},
+	{name: "wildcard overridden", header: "*, br;q=0", offered: []string{"br", "gzip"}, expected: "gzip"},
+	{name: "case and spaces", header: " GZIP ; Q=0.8 ", offered: []string{"gzip"}, expected: "gzip"},
+	{name: "invalid quality", header: "br;q=2, gzip", offered: []string{"br", "gzip"}, expected: "gzip"},
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, e := range negotiateEncodingTests {
+		if got := negotiateEncoding(e.header, e.offered...); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+var negotiateMediaTypeTests = []struct {
+	name     string
+	header   string
+	expected string
+}{
+	{name: "no header", header: "", expected: "application/json"},
+	{name: "exact", header: "application/cbor", expected: "application/cbor"},
+	{name: "quality", header: "application/json;q=0.5, application/xml", expected: "application/xml"},
+	{name: "specific range wins", header: "application/*;q=0.9, application/json;q=0.1", expected: "application/xml"},
+	{name: "wildcard", header: "*/*", expected: "application/json"},
+	{name: "alias", header: "application/x-msgpack", expected: "application/msgpack"},
+	{name: "alias of text", header: "text/xml, */*;q=0.1", expected: "application/xml"},
+	{name: "none acceptable", header: "text/html", expected: ""},
+	{name: "refused", header: "application/json;q=0, */*", expected: "application/xml"},
+}
+
+func TestNegotiateMediaType(t *testing.T) {
+	offered := []string{"application/json", "application/xml", "application/cbor", "application/msgpack"}
+	for _, e := range negotiateMediaTypeTests {
+		if got := negotiateMediaType(e.header, offered...); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
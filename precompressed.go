@@ -0,0 +1,66 @@
+package toolkit
+
+import (
+	"io/fs"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// precompressedEncodings lists the content codings served from precompressed siblings, in the order
+// they are preferred when the client accepts several of them equally
+var precompressedEncodings = []string{"br", "zstd", "gzip"}
+
+// precompressedExtensions maps each of precompressedEncodings to the extension of its siblings
+var precompressedExtensions = map[string]string{"br": ".br", "zstd": ".zst", "gzip": ".gz"}
+
+// openPrecompressed opens the precompressed sibling of the file name in fsys, such as name.br, that
+// best matches the Accept-Encoding header of the client. It returns a nil file if there is none.
+func openPrecompressed(fsys fs.FS, name, acceptEncoding string) (string, fs.File, fs.FileInfo) {
+	offered := slices.Clone(precompressedEncodings)
+	for {
+		encoding := negotiateEncoding(acceptEncoding, offered...)
+		if encoding == "" {
+			return "", nil, nil
+		}
+		if f, err := fsys.Open(name + precompressedExtensions[encoding]); err == nil {
+			if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+				return encoding, f, info
+			}
+			_ = f.Close()
+		}
+		// the preferred sibling is missing, fall back to the next coding the client accepts
+		offered = slices.DeleteFunc(offered, func(coding string) bool {
+			return coding == encoding
+		})
+	}
+}
+
+// compressible reports whether content of the given type is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/ecmascript", "application/x-ndjson", "application/wasm", "image/svg+xml", "image/bmp":
+		return true
+	}
+	return false
+}
+
+// encodedETag derives the ETag of an encoded representation from the ETag of the file, since the
+// encoded bytes differ from the file they were produced from
+func encodedETag(etag, encoding string) string {
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
@@ -0,0 +1,105 @@
+package toolkit
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var precompressedTests = []struct {
+	name           string
+	acceptEncoding string
+	expectEncoding string
+	expectBody     string
+}{
+	{name: "no encoding", acceptEncoding: "", expectEncoding: "", expectBody: "raw"},
+	{name: "brotli preferred", acceptEncoding: "gzip, br", expectEncoding: "br", expectBody: "brotli"},
+	{name: "gzip only", acceptEncoding: "gzip", expectEncoding: "gzip", expectBody: "gzipped"},
+	{name: "brotli refused", acceptEncoding: "br;q=0, *", expectEncoding: "gzip", expectBody: "gzipped"},
+	{name: "missing sibling", acceptEncoding: "zstd", expectEncoding: "", expectBody: "raw"},
+}
+
+func TestTools_DownloadFS_Precompressed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"export.json":    {Data: []byte("raw")},
+		"export.json.br": {Data: []byte("brotli")},
+		"export.json.gz": {Data: []byte("gzipped")},
+	}
+	testTools := Tools{Precompressed: true, ETags: true}
+	etags := make(map[string]string)
+	for _, e := range precompressedTests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", e.acceptEncoding)
+		testTools.DownloadFS(w, r, fsys, "export.json", DownloadOptions{})
+		res := w.Result()
+		if res.Header.Get("Content-Encoding") != e.expectEncoding {
+			t.Errorf("%s: expected encoding %q, got %q", e.name, e.expectEncoding, res.Header.Get("Content-Encoding"))
+		}
+		if body, _ := io.ReadAll(res.Body); string(body) != e.expectBody {
+			t.Errorf("%s: expected body %q, got %q", e.name, e.expectBody, body)
+		}
+		if res.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: wrong Content-Type %s", e.name, res.Header.Get("Content-Type"))
+		}
+		if res.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: wrong Vary %q", e.name, res.Header.Get("Vary"))
+		}
+		etag := res.Header.Get("ETag")
+		if other, ok := etags[etag]; ok && other != e.expectEncoding {
+			t.Errorf("%s: ETag %s is shared by encodings %q and %q", e.name, etag, other, e.expectEncoding)
+		}
+		etags[etag] = e.expectEncoding
+	}
+}
+
+func TestTools_DownloadFS_Compress(t *testing.T) {
+	data := strings.Repeat(`{"id":1,"name":"foo"},`, 100)
+	fsys := fstest.MapFS{
+		"export.json": {Data: []byte(data)},
+		"small.json":  {Data: []byte(`{}`)},
+		"pic.jpg":     {Data: []byte(data)},
+	}
+	testTools := Tools{CompressMinSize: 1024}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	testTools.DownloadFS(w, r, fsys, "export.json", DownloadOptions{})
+	res := w.Result()
+	if res.Header.Get("Content-Encoding") != "gzip" || res.Header.Get("Content-Length") != "" {
+		t.Fatalf("expected a gzipped response without length, got %q and %q", res.Header.Get("Content-Encoding"), res.Header.Get("Content-Length"))
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(zr); string(body) != data {
+		t.Error("decompressed body differs from the file")
+	}
+
+	uncompressed := []struct {
+		name, file, acceptEncoding, rangeHeader string
+	}{
+		{name: "below threshold", file: "small.json", acceptEncoding: "gzip"},
+		{name: "not compressible", file: "pic.jpg", acceptEncoding: "gzip"},
+		{name: "not accepted", file: "export.json"},
+		{name: "range", file: "export.json", acceptEncoding: "gzip", rangeHeader: "bytes=0-9"},
+	}
+	for _, e := range uncompressed {
+		w = httptest.NewRecorder()
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", e.acceptEncoding)
+		if e.rangeHeader != "" {
+			r.Header.Set("Range", e.rangeHeader)
+		}
+		testTools.DownloadFS(w, r, fsys, e.file, DownloadOptions{})
+		if w.Result().Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: unexpected Content-Encoding %s", e.name, w.Result().Header.Get("Content-Encoding"))
+		}
+	}
+}
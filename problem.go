@@ -0,0 +1,176 @@
+package toolkit
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors returned by UploadFiles, which ErrorJSON maps to problem types
+var (
+	ErrFileTooBig           = errors.New("the uploaded file is too big")
+	ErrFileTypeNotPermitted = errors.New("the uploaded file type is not permitted")
+)
+
+// BodyTooLargeError is returned when a request body is larger than the configured limit
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+// ProblemContentType is the media type of RFC 9457 problem details
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details document. Extensions are serialised as additional
+// members next to the standard ones, which they cannot override.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// problemMembers are the standard members of a problem details document
+type problemMembers struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// MarshalJSON serialises the standard members of p together with its extensions
+func (p Problem) MarshalJSON() ([]byte, error) {
+	standard, err := json.Marshal(problemMembers{Type: p.Type, Title: p.Title, Status: p.Status, Detail: p.Detail, Instance: p.Instance})
+	if err != nil || len(p.Extensions) == 0 {
+		return standard, err
+	}
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(standard, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		members[k] = v
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON reads a problem details document, keeping unknown members as extensions
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var standard problemMembers
+	if err := json.Unmarshal(data, &standard); err != nil {
+		return err
+	}
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, k)
+	}
+	*p = Problem{Type: standard.Type, Title: standard.Title, Status: standard.Status, Detail: standard.Detail, Instance: standard.Instance}
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// problemType returns the URI of the toolkit problem type named name, or an empty string, which stands
+// for about:blank, if Tools.ProblemTypeBase is not set
+func (tools *Tools) problemType(name string) string {
+	if tools.ProblemTypeBase == "" {
+		return ""
+	}
+	return tools.ProblemTypeBase + name
+}
+
+// problemFor builds the problem details document describing err. Errors that are, or wrap, a Problem
+// are used as is; typed toolkit errors get their own problem type and status; anything else gets
+// status, or 400 if status is zero.
+func (tools *Tools) problemFor(err error, status int) *Problem {
+	var problem *Problem
+	var validationErrors ValidationErrors
+	var quotaExceeded *QuotaExceededError
+	var unsafeSVG *UnsafeSVGError
+	var bodyTooLarge *BodyTooLargeError
+	var unsupportedMediaType *UnsupportedMediaTypeError
+	var unsupportedEncoding *UnsupportedEncodingError
+	var patchError *PatchError
+	switch {
+	case errors.As(err, &problem):
+		p := *problem
+		if status != 0 {
+			p.Status = status
+		}
+		p.Status = cmp.Or(p.Status, http.StatusBadRequest)
+		if p.Title == "" && p.Type == "" {
+			p.Title = http.StatusText(p.Status)
+		}
+		return &p
+	case errors.As(err, &validationErrors):
+		problem = &Problem{Type: tools.problemType("validation-error"), Title: "Validation failed", Status: http.StatusUnprocessableEntity,
+			Extensions: map[string]interface{}{"errors": validationErrors}}
+	case errors.As(err, &quotaExceeded):
+		problem = &Problem{Type: tools.problemType("quota-exceeded"), Title: "Storage quota exceeded", Status: http.StatusRequestEntityTooLarge,
+			Extensions: map[string]interface{}{"limit": quotaExceeded.Limit, "usage": quotaExceeded.Usage}}
+	case errors.As(err, &unsafeSVG):
+		problem = &Problem{Type: tools.problemType("unsafe-svg"), Title: "Unsafe SVG", Status: http.StatusUnprocessableEntity}
+		if len(unsafeSVG.Reasons) > 0 {
+			problem.Extensions = map[string]interface{}{"reasons": unsafeSVG.Reasons}
+		}
+	case errors.As(err, &bodyTooLarge):
+		problem = &Problem{Type: tools.problemType("body-too-large"), Title: "Request body too large", Status: http.StatusRequestEntityTooLarge,
+			Extensions: map[string]interface{}{"limit": bodyTooLarge.Limit}}
+	case errors.As(err, &unsupportedMediaType):
+		problem = &Problem{Type: tools.problemType("unsupported-media-type"), Title: "Unsupported media type", Status: http.StatusUnsupportedMediaType,
+			Extensions: map[string]interface{}{"supported": unsupportedMediaType.Supported}}
+	case errors.As(err, &unsupportedEncoding):
+		problem = &Problem{Type: tools.problemType("unsupported-encoding"), Title: "Unsupported content encoding", Status: http.StatusUnsupportedMediaType,
+			Extensions: map[string]interface{}{"supported": unsupportedEncoding.Supported}}
+	case errors.As(err, &patchError):
+		problem = &Problem{Type: tools.problemType("invalid-patch"), Title: "Invalid patch", Status: http.StatusUnprocessableEntity,
+			Extensions: map[string]interface{}{"operation": patchError.Index, "path": patchError.Path}}
+		if errors.Is(err, ErrPatchTestFailed) {
+			problem.Type, problem.Title, problem.Status = tools.problemType("patch-test-failed"), "Patch test failed", http.StatusConflict
+		}
+	case errors.Is(err, ErrFileTooBig):
+		problem = &Problem{Type: tools.problemType("file-too-big"), Title: "Uploaded file too big", Status: http.StatusRequestEntityTooLarge}
+	case errors.Is(err, ErrFileTypeNotPermitted):
+		problem = &Problem{Type: tools.problemType("file-type-not-permitted"), Title: "File type not permitted", Status: http.StatusUnsupportedMediaType}
+	case errors.Is(err, ErrQuarantineFull):
+		problem = &Problem{Type: tools.problemType("quarantine-full"), Title: "Upload queue full", Status: http.StatusServiceUnavailable}
+	default:
+		problem = &Problem{Status: cmp.Or(status, http.StatusBadRequest)}
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Type == "" {
+		// the title of an about:blank problem must be the status phrase
+		problem.Title = ""
+	}
+	if status != 0 {
+		problem.Status = status
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	problem.Detail = err.Error()
+	return problem
+}
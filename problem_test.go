@@ -0,0 +1,114 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var problemTests = []struct {
+	name         string
+	err          error
+	status       []int
+	expectStatus int
+	expectType   string
+	expectTitle  string
+	expectDetail string
+	expectExt    []string
+}{
+	{name: "plain error", err: errors.New("boom"), expectStatus: http.StatusBadRequest, expectTitle: "Bad Request", expectDetail: "boom"},
+	{name: "plain error with status", err: errors.New("boom"), status: []int{http.StatusServiceUnavailable}, expectStatus: http.StatusServiceUnavailable, expectTitle: "Service Unavailable", expectDetail: "boom"},
+	{name: "validation", err: ValidationErrors{{Field: "name", Rule: "required", Message: "is required"}}, expectStatus: http.StatusUnprocessableEntity,
+		expectType: "https://example.com/problems/validation-error", expectTitle: "Validation failed", expectDetail: "name is required", expectExt: []string{"errors"}},
+	{name: "quota", err: fmt.Errorf("upload: %w", &QuotaExceededError{Owner: "jack", Limit: 10, Usage: 12}), expectStatus: http.StatusRequestEntityTooLarge,
+		expectType: "https://example.com/problems/quota-exceeded", expectTitle: "Storage quota exceeded", expectDetail: `upload: storage quota of 10 bytes exceeded for "jack"`, expectExt: []string{"limit", "usage"}},
+	{name: "unsafe svg", err: &UnsafeSVGError{FileName: "a.svg", Reasons: []string{"script element"}}, expectStatus: http.StatusUnprocessableEntity,
+		expectType: "https://example.com/problems/unsafe-svg", expectTitle: "Unsafe SVG", expectDetail: `the uploaded SVG "a.svg" is not safe: script element`, expectExt: []string{"reasons"}},
+	{name: "body too large", err: &BodyTooLargeError{Limit: 1024}, expectStatus: http.StatusRequestEntityTooLarge,
+		expectType: "https://example.com/problems/body-too-large", expectTitle: "Request body too large", expectDetail: "body must not be larger than 1024 bytes", expectExt: []string{"limit"}},
+	{name: "media type", err: &UnsupportedMediaTypeError{ContentType: "text/csv", Supported: []string{"application/json"}}, expectStatus: http.StatusUnsupportedMediaType,
+		expectType: "https://example.com/problems/unsupported-media-type", expectTitle: "Unsupported media type", expectDetail: `unsupported content type "text/csv"`, expectExt: []string{"supported"}},
+	{name: "encoding", err: &UnsupportedEncodingError{Encoding: "br", Supported: []string{"gzip"}}, expectStatus: http.StatusUnsupportedMediaType,
+		expectType: "https://example.com/problems/unsupported-encoding", expectTitle: "Unsupported content encoding", expectDetail: `unsupported content encoding "br"`, expectExt: []string{"supported"}},
+	{name: "invalid patch", err: &PatchError{Index: 2, Op: "remove", Path: "/a/1", Err: errors.New("index out of range")}, expectStatus: http.StatusUnprocessableEntity,
+		expectType: "https://example.com/problems/invalid-patch", expectTitle: "Invalid patch", expectDetail: `patch operation 2 (remove) at "/a/1": index out of range`, expectExt: []string{"operation", "path"}},
+	{name: "patch test failed", err: &PatchError{Op: "test", Path: "/v", Err: ErrPatchTestFailed}, expectStatus: http.StatusConflict,
+		expectType: "https://example.com/problems/patch-test-failed", expectTitle: "Patch test failed", expectDetail: `patch operation 0 (test) at "/v": value does not match`, expectExt: []string{"operation", "path"}},
+	{name: "file type", err: ErrFileTypeNotPermitted, expectStatus: http.StatusUnsupportedMediaType,
+		expectType: "https://example.com/problems/file-type-not-permitted", expectTitle: "File type not permitted", expectDetail: "the uploaded file type is not permitted"},
+	{name: "quarantine full", err: ErrQuarantineFull, expectStatus: http.StatusServiceUnavailable,
+		expectType: "https://example.com/problems/quarantine-full", expectTitle: "Upload queue full", expectDetail: "the upload quarantine is full"},
+	{name: "file too big", err: ErrFileTooBig, expectStatus: http.StatusRequestEntityTooLarge,
+		expectType: "https://example.com/problems/file-too-big", expectTitle: "Uploaded file too big", expectDetail: "the uploaded file is too big"},
+	{name: "custom problem", err: &Problem{Type: "https://example.com/problems/out-of-credit", Title: "You do not have enough credit", Status: http.StatusForbidden,
+		Detail: "Your current balance is 30, but that costs 50", Instance: "/account/12345/msgs/abc", Extensions: map[string]interface{}{"balance": 30}},
+		expectStatus: http.StatusForbidden, expectType: "https://example.com/problems/out-of-credit", expectTitle: "You do not have enough credit",
+		expectDetail: "Your current balance is 30, but that costs 50", expectExt: []string{"balance"}},
+	{name: "bare problem", err: &Problem{Detail: "nope"}, expectStatus: http.StatusBadRequest, expectTitle: "Bad Request", expectDetail: "nope"},
+}
+
+func TestTools_ErrorJSON_Problem(t *testing.T) {
+	testTools := Tools{ProblemDetails: true, ProblemTypeBase: "https://example.com/problems/"}
+	for _, e := range problemTests {
+		rr := httptest.NewRecorder()
+		if err := testTools.ErrorJSON(rr, e.err, e.status...); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if rr.Code != e.expectStatus {
+			t.Errorf("%s: expected status %d, got %d", e.name, e.expectStatus, rr.Code)
+		}
+		if rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: wrong Content-Type %s", e.name, rr.Header().Get("Content-Type"))
+		}
+		var problem Problem
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if problem.Status != e.expectStatus || problem.Type != e.expectType || problem.Title != e.expectTitle || problem.Detail != e.expectDetail {
+			t.Errorf("%s: unexpected problem %+v", e.name, problem)
+		}
+		for _, k := range e.expectExt {
+			if _, ok := problem.Extensions[k]; !ok {
+				t.Errorf("%s: missing extension %s", e.name, k)
+			}
+		}
+		if len(problem.Extensions) != len(e.expectExt) {
+			t.Errorf("%s: unexpected extensions %v", e.name, problem.Extensions)
+		}
+	}
+}
+
+func TestTools_ErrorJSON_ProblemAboutBlank(t *testing.T) {
+	testTools := Tools{ProblemDetails: true}
+	rr := httptest.NewRecorder()
+	_ = testTools.ErrorJSON(rr, &QuotaExceededError{Owner: "jack", Limit: 10, Usage: 12})
+	var problem Problem
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Type != "" || problem.Title != "Request Entity Too Large" || problem.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+}
+
+func TestProblem_MarshalJSON(t *testing.T) {
+	problem := Problem{Title: "Forbidden", Status: http.StatusForbidden, Extensions: map[string]interface{}{"status": 200, "account": "12345"}}
+	out, err := json.Marshal(problem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"account":"12345","status":403,"title":"Forbidden"}` {
+		t.Errorf("unexpected document %s", out)
+	}
+	var decoded Problem
+	if err = json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, Problem{Title: "Forbidden", Status: http.StatusForbidden, Extensions: map[string]interface{}{"account": "12345"}}) {
+		t.Errorf("unexpected problem %+v", decoded)
+	}
+}
@@ -0,0 +1,282 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// UploadStatus is the processing state of a quarantined upload
+type UploadStatus string
+
+const (
+	// UploadPending is the status of a file waiting in the queue for a worker
+	UploadPending UploadStatus = "pending"
+	// UploadProcessing is the status of a file whose processors are running
+	UploadProcessing UploadStatus = "processing"
+	// UploadDone is the status of a file promoted to its upload directory
+	UploadDone UploadStatus = "done"
+	// UploadFailed is the status of a file that was rejected, or could not be promoted, and was deleted
+	UploadFailed UploadStatus = "failed"
+)
+
+// quarantineQueueSize is the number of uploads that can wait for a worker
+const quarantineQueueSize = 1024
+
+// ErrQuarantineFull is returned by uploads when every worker of the quarantine is busy and its queue is
+// full. The upload is discarded, and can be retried later.
+var ErrQuarantineFull = errors.New("the upload quarantine is full")
+
+// ErrQuarantineNotStarted is returned by uploads to a Quarantine that was not built by NewQuarantine,
+// and so has no workers
+var ErrQuarantineNotStarted = errors.New("the upload quarantine is not started, use NewQuarantine")
+
+// FileProcessor validates or transforms a quarantined file in place. Returning an error
+// rejects the file, which is then deleted instead of being promoted to the upload directory.
+type FileProcessor func(ctx context.Context, path string, file *UploadedFile) error
+
+// UploadJob describes a quarantined upload and its current status
+type UploadJob struct {
+	File   UploadedFile
+	Status UploadStatus
+	Err    error
+	// Path is where the file currently lives: the quarantine directory while pending,
+	// the upload directory once done, and empty after a failure
+	Path string
+}
+
+// Quarantine holds uploaded files in a separate directory while a pool of workers runs
+// the configured processors on them, and promotes them to their upload directory on success
+type Quarantine struct {
+	Dir        string
+	Processors []FileProcessor
+	// OnComplete, if set, is called from a worker after a job succeeds or fails.
+	// It must be set before the first upload.
+	OnComplete func(job UploadJob)
+
+	mu     sync.Mutex
+	jobs   map[string]*UploadJob
+	queue  chan quarantined
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	// sending guards the queue against being closed while an upload is being enqueued
+	sending sync.RWMutex
+	closed  bool
+}
+
+type quarantined struct {
+	id       string
+	finalDir string
+	quota    *Quota
+	meta     *FileMetadata
+}
+
+// NewQuarantine creates the quarantine directory if needed and starts the given number of workers
+func NewQuarantine(dir string, workers int, processors ...FileProcessor) (*Quarantine, error) {
+	var tools Tools
+	if err := tools.CreateDirIfNotExist(dir); err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	q := &Quarantine{
+		Dir:        dir,
+		Processors: processors,
+		jobs:       make(map[string]*UploadJob),
+		queue:      make(chan quarantined, quarantineQueueSize),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q, nil
+}
+
+// Status returns a copy of the job for the given file ID
+func (q *Quarantine) Status(id string) (UploadJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return UploadJob{}, false
+	}
+	return *job, true
+}
+
+// Forget drops a finished job from the status table
+func (q *Quarantine) Forget(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job, ok := q.jobs[id]; ok && (job.Status == UploadDone || job.Status == UploadFailed) {
+		delete(q.jobs, id)
+	}
+}
+
+// Close stops accepting uploads and waits for queued jobs to finish. If ctx is done first,
+// running processors are cancelled and the jobs still queued are failed.
+func (q *Quarantine) Close(ctx context.Context) error {
+	if !q.started() {
+		return nil
+	}
+	q.sending.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.sending.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// started reports whether the quarantine was built by NewQuarantine
+func (q *Quarantine) started() bool {
+	return q.jobs != nil && q.queue != nil
+}
+
+// path returns the location of a quarantined file
+func (q *Quarantine) path(id string) string {
+	return filepath.Join(q.Dir, id)
+}
+
+// enqueue registers a file already written to the quarantine directory and queues it for processing.
+// It does not wait for room in the queue, so that Close is never held up by an upload: when the queue
+// is full it returns ErrQuarantineFull, and the caller removes the file.
+func (q *Quarantine) enqueue(file UploadedFile, finalDir string, quota *Quota, meta *FileMetadata) error {
+	q.sending.RLock()
+	defer q.sending.RUnlock()
+	if !q.started() {
+		return ErrQuarantineNotStarted
+	}
+	if q.closed {
+		return errors.New("the upload quarantine is closed")
+	}
+	// the job is registered before it is queued, so that a worker always finds it
+	q.mu.Lock()
+	q.jobs[file.ID] = &UploadJob{File: file, Status: UploadPending, Path: q.path(file.ID)}
+	q.mu.Unlock()
+	select {
+	case q.queue <- quarantined{id: file.ID, finalDir: finalDir, quota: quota, meta: meta}:
+		return nil
+	default:
+		q.mu.Lock()
+		delete(q.jobs, file.ID)
+		q.mu.Unlock()
+		return ErrQuarantineFull
+	}
+}
+
+func (q *Quarantine) work() {
+	defer q.wg.Done()
+	for item := range q.queue {
+		q.process(item)
+	}
+}
+
+func (q *Quarantine) process(item quarantined) {
+	q.mu.Lock()
+	job := q.jobs[item.id]
+	job.Status = UploadProcessing
+	file := job.File
+	q.mu.Unlock()
+
+	src := q.path(item.id)
+	dst := filepath.Join(item.finalDir, file.NewFileName)
+	landedSize := file.FileSize
+	err := q.ctx.Err()
+	for _, p := range q.Processors {
+		if err != nil {
+			break
+		}
+		err = p(q.ctx, src, &file)
+	}
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(src); err == nil {
+			file.FileSize = info.Size()
+			if len(q.Processors) > 0 {
+				file.Checksum, err = checksumFile(src)
+			}
+		}
+		if err == nil {
+			replaced := item.quota.replaced(dst)
+			if err = moveFile(src, dst); err == nil {
+				replaced.release()
+			}
+		}
+		if err == nil && item.meta != nil {
+			item.meta.UploadedFile = file
+			if err = writeMetadata(item.finalDir, item.meta); err != nil {
+				_ = os.Remove(dst)
+			}
+		}
+	}
+
+	if item.quota != nil && file.Owner != "" {
+		// give back what the quarantined file took, or account for processors changing its size
+		if err != nil {
+			_ = item.quota.Release(file.Owner, landedSize)
+		} else if file.FileSize != landedSize {
+			_, _ = item.quota.Store.Add(file.Owner, file.FileSize-landedSize)
+		}
+	}
+
+	q.mu.Lock()
+	job.File = file
+	if err != nil {
+		_ = os.Remove(src)
+		job.Status, job.Err, job.Path = UploadFailed, err, ""
+	} else {
+		job.Status, job.Path = UploadDone, dst
+	}
+	result := *job
+	q.mu.Unlock()
+	if q.OnComplete != nil {
+		q.OnComplete(result)
+	}
+}
+
+// moveFile renames src to dst, falling back to copy and delete when they are on different file systems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err = out.Close(); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
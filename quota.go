@@ -0,0 +1,329 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// QuotaStore keeps track of how many bytes each owner has stored
+type QuotaStore interface {
+	// Usage returns the number of bytes currently stored by owner
+	Usage(owner string) (int64, error)
+	// Add adjusts the usage of owner by delta, which may be negative, and returns the new usage
+	Add(owner string, delta int64) (int64, error)
+}
+
+// SharedQuotaOwner is the owner of every upload when Quota.Owner is not set, so that Limit caps them all
+const SharedQuotaOwner = "*"
+
+// quotaReserveStep is the most bytes an upload reserves at a time while it is read, so that a
+// FileQuotaStore is not rewritten for every read
+const quotaReserveStep = 256 << 10
+
+// ErrQuotaStoreNotSet is returned by UploadFiles when a Quota has no Store
+var ErrQuotaStoreNotSet = errors.New("quota store is not set")
+
+// Quota limits how much each owner (a user, a tenant...) may store through UploadFiles. Uploads
+// reserve their bytes in the store as they are read, so concurrent uploads by the same owner cannot
+// exceed the limit together. Bytes are reserved a little ahead of those read, so an upload may be
+// refused early by up to a sixteenth of the remaining quota, and at most 256 KiB, for every other
+// upload of the owner in progress.
+type Quota struct {
+	Store QuotaStore
+	// Limit is the number of bytes each owner may store
+	Limit int64
+	// LimitFunc, if set, overrides Limit for individual owners
+	LimitFunc func(owner string) int64
+	// Owner extracts the owner key from an upload request. If it is not set, every upload belongs to
+	// SharedQuotaOwner.
+	Owner func(r *http.Request) (string, error)
+}
+
+// QuotaExceededError is returned when an upload would take an owner over their quota
+type QuotaExceededError struct {
+	Owner string
+	Limit int64
+	Usage int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota of %d bytes exceeded for %q", e.Limit, e.Owner)
+}
+
+// limit returns the quota of owner
+func (q *Quota) limit(owner string) int64 {
+	if q.LimitFunc != nil {
+		return q.LimitFunc(owner)
+	}
+	return q.Limit
+}
+
+// Release gives back size bytes of the quota of owner, e.g. after one of their files was deleted
+func (q *Quota) Release(owner string, size int64) error {
+	if q.Store == nil {
+		return ErrQuotaStoreNotSet
+	}
+	_, err := q.Store.Add(owner, -size)
+	return err
+}
+
+// replacedFile is a stored file that an upload overwrites, whose size goes back to its owner
+type replacedFile struct {
+	q     *Quota
+	owner string
+	size  int64
+}
+
+// replaced returns the stored file at path that an upload is about to overwrite, or nil. Like
+// DeleteUploadedFile, it charges the file to the owner in its metadata sidecar.
+func (q *Quota) replaced(path string) *replacedFile {
+	if q == nil {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	var owner string
+	var tools Tools
+	if meta, err := tools.ReadMetadata(filepath.Dir(path), filepath.Base(path)); err == nil {
+		owner = meta.Owner
+	}
+	if owner == "" && q.Owner == nil {
+		owner = SharedQuotaOwner
+	}
+	if owner == "" {
+		return nil
+	}
+	return &replacedFile{q: q, owner: owner, size: info.Size()}
+}
+
+// release gives the size of the replaced file back to its owner
+func (rf *replacedFile) release() {
+	if rf != nil {
+		_ = rf.q.Release(rf.owner, rf.size)
+	}
+}
+
+// restore charges the size of the replaced file again, when it was not replaced after all
+func (rf *replacedFile) restore() {
+	if rf != nil {
+		_ = rf.q.Release(rf.owner, -rf.size)
+	}
+}
+
+// owner returns the owner of an upload request
+func (q *Quota) owner(r *http.Request) (string, error) {
+	if q.Store == nil {
+		return "", ErrQuotaStoreNotSet
+	}
+	if q.Owner == nil {
+		return SharedQuotaOwner, nil
+	}
+	return q.Owner(r)
+}
+
+// check returns a QuotaExceededError if owner has no quota left
+func (q *Quota) check(owner string) error {
+	_, err := q.reader(owner, nil)
+	return err
+}
+
+// reader wraps r so that the bytes read are reserved in the quota of owner, and reading fails once
+// owner runs out of quota
+func (q *Quota) reader(owner string, r io.Reader) (*quotaReader, error) {
+	usage, err := q.Store.Usage(owner)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.limit(owner)
+	if usage >= limit {
+		return nil, &QuotaExceededError{Owner: owner, Limit: limit, Usage: usage}
+	}
+	step := min(quotaReserveStep, (limit-usage)/16)
+	return &quotaReader{q: q, owner: owner, limit: limit, step: step, r: r}, nil
+}
+
+// quotaReader reserves the bytes it reads in the quota store, and fails with a QuotaExceededError as
+// soon as they take the owner over the limit, so that an upload is aborted mid-stream instead of after
+// it has been written in full. The reservation must be settled with commit or cancel.
+type quotaReader struct {
+	q        *Quota
+	owner    string
+	limit    int64
+	step     int64
+	r        io.Reader
+	read     int64
+	reserved int64
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	qr.read += int64(n)
+	if qr.read > qr.reserved {
+		step := max(qr.read-qr.reserved, qr.step)
+		usage, addErr := qr.q.Store.Add(qr.owner, step)
+		if addErr != nil {
+			return n, addErr
+		}
+		qr.reserved += step
+		if usage > qr.limit {
+			// give back what was reserved ahead of the bytes read before deciding
+			if ahead := qr.reserved - qr.read; ahead > 0 {
+				if usage, addErr = qr.q.Store.Add(qr.owner, -ahead); addErr != nil {
+					return n, addErr
+				}
+				qr.reserved = qr.read
+			}
+			if usage > qr.limit {
+				return n, &QuotaExceededError{Owner: qr.owner, Limit: qr.limit, Usage: usage}
+			}
+		}
+	}
+	return n, err
+}
+
+// commit settles the reservation to size, the number of bytes stored
+func (qr *quotaReader) commit(size int64) error {
+	if _, err := qr.q.Store.Add(qr.owner, size-qr.reserved); err != nil {
+		return err
+	}
+	qr.reserved = size
+	return nil
+}
+
+// cancel gives back everything reserved
+func (qr *quotaReader) cancel() {
+	if _, err := qr.q.Store.Add(qr.owner, -qr.reserved); err == nil {
+		qr.reserved = 0
+	}
+}
+
+// DeleteUploadedFile removes a stored file together with its metadata sidecar and, if a Quota is set,
+// releases its size from the quota of owner. An empty owner is looked up in the sidecar.
+func (tools *Tools) DeleteUploadedFile(owner, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("cannot delete a directory")
+	}
+	if owner == "" {
+		if meta, err := tools.ReadMetadata(filepath.Dir(path), filepath.Base(path)); err == nil {
+			owner = meta.Owner
+		}
+	}
+	if owner == "" && tools.Quota != nil && tools.Quota.Owner == nil {
+		owner = SharedQuotaOwner
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	if err = os.Remove(path + MetadataSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if tools.Quota != nil && owner != "" {
+		return tools.Quota.Release(owner, info.Size())
+	}
+	return nil
+}
+
+// MemoryQuotaStore is a QuotaStore that keeps usage in memory
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// NewMemoryQuotaStore returns an empty MemoryQuotaStore
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[string]int64)}
+}
+
+// Usage returns the number of bytes currently stored by owner
+func (s *MemoryQuotaStore) Usage(owner string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[owner], nil
+}
+
+// Add adjusts the usage of owner by delta and returns the new usage
+func (s *MemoryQuotaStore) Add(owner string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage[owner] = max(s.usage[owner]+delta, 0)
+	return s.usage[owner], nil
+}
+
+// FileQuotaStore is a QuotaStore that persists usage to a JSON file, rewritten atomically on every change
+type FileQuotaStore struct {
+	mu    sync.Mutex
+	path  string
+	usage map[string]int64
+}
+
+// NewFileQuotaStore loads usage from the JSON file at path, which is created on the first change if
+// it does not exist
+func NewFileQuotaStore(path string) (*FileQuotaStore, error) {
+	s := &FileQuotaStore{path: path, usage: make(map[string]int64)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &s.usage); err != nil {
+		return nil, fmt.Errorf("error reading quota file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Usage returns the number of bytes currently stored by owner
+func (s *FileQuotaStore) Usage(owner string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[owner], nil
+}
+
+// Add adjusts the usage of owner by delta, saves the file and returns the new usage
+func (s *FileQuotaStore) Add(owner string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.usage[owner]
+	s.usage[owner] = max(previous+delta, 0)
+	data, err := json.Marshal(s.usage)
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		s.usage[owner] = previous
+		return previous, err
+	}
+	return s.usage[owner], nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
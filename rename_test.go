@@ -0,0 +1,66 @@
+package toolkit
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var renameTests = []struct {
+	name     string
+	strategy RenameStrategy
+	original string
+	pattern  string
+}{
+	{name: "random", strategy: RenameRandom, original: "report.pdf", pattern: `^[a-zA-Z0-9_+]{25}\.pdf$`},
+	{name: "uuid", strategy: RenameUUID, original: "report.pdf", pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.pdf$`},
+	{name: "timestamp", strategy: RenameTimestamp, original: "report.pdf", pattern: `^\d{8}T\d{6}\.\d{9}Z-[a-z0-9]{6}\.pdf$`},
+	{name: "content hash", strategy: RenameContentHash, original: "report.pdf", pattern: `^2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae\.pdf$`},
+	{name: "slug", strategy: RenameSlug, original: "Quarterly Report.PDF", pattern: `^quarterly-report-[a-z0-9]{4}\.pdf$`},
+	{name: "slug fallback", strategy: RenameSlug, original: "こんにちは.txt", pattern: `^file-[a-z0-9]{4}\.txt$`},
+	{name: "unsafe extension", strategy: RenameSlug, original: "x.p\"hp", pattern: `^x-[a-z0-9]{4}$`},
+}
+
+func TestTools_RenameStrategy(t *testing.T) {
+	for _, e := range renameTests {
+		testTools := Tools{RenameStrategy: e.strategy}
+		content := strings.NewReader("foo")
+		name, err := testTools.newFileName(e.original, content)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+		if !regexp.MustCompile(e.pattern).MatchString(name) {
+			t.Errorf("%s: name %q does not match %s", e.name, name, e.pattern)
+		}
+		if content.Len() != 3 {
+			t.Errorf("%s: content was not rewound", e.name)
+		}
+	}
+}
+
+func TestTools_RenameFunc(t *testing.T) {
+	testTools := Tools{RenameFunc: func(originalName string, content io.Reader) (string, error) {
+		return "custom-" + originalName, nil
+	}}
+	name, err := testTools.newFileName("a.txt", strings.NewReader("foo"))
+	if err != nil || name != "custom-a.txt" {
+		t.Errorf("expected custom-a.txt, got %q (%v)", name, err)
+	}
+
+	testTools.RenameFunc = func(originalName string, content io.Reader) (string, error) {
+		return "../escape.txt", nil
+	}
+	if _, err = testTools.newFileName("a.txt", strings.NewReader("foo")); err == nil {
+		t.Error("expected error for a name containing a path")
+	}
+
+	testTools.RenameFunc = func(originalName string, content io.Reader) (string, error) {
+		return "", errors.New("boom")
+	}
+	if _, err = testTools.newFileName("a.txt", strings.NewReader("foo")); err == nil {
+		t.Error("expected error from the rename function")
+	}
+}
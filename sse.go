@@ -0,0 +1,266 @@
+package toolkit
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSSEKeepAliveInterval is how often event streams get a keepalive comment when
+// Tools.SSEKeepAliveInterval is not set
+const defaultSSEKeepAliveInterval = 15 * time.Second
+
+// ErrInvalidEvent is returned when an event's id or name contains a line break, which would corrupt
+// the event stream
+var ErrInvalidEvent = errors.New("event id and name must not contain line breaks")
+
+// ErrEventStreamClosed is returned when sending on a closed EventStream
+var ErrEventStreamClosed = errors.New("event stream is closed")
+
+// Event is a Server-Sent Event. Data is sent JSON encoded; ID, Event and Retry are sent only when set.
+type Event struct {
+	ID    string
+	Event string
+	Retry time.Duration
+	Data  any
+}
+
+// ReplayBuffer keeps sent events so that a client reconnecting with a Last-Event-ID header can be sent
+// the events it missed. Only events with an ID are added.
+type ReplayBuffer interface {
+	Add(event Event)
+	// Since returns the events added after the one with the given ID, or every event kept if that ID
+	// is not known (anymore)
+	Since(lastEventID string) []Event
+}
+
+// RingBuffer is an in-memory ReplayBuffer that keeps the most recent events. It is safe for concurrent
+// use, so that one buffer can be shared by every stream of a topic.
+type RingBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewRingBuffer returns a RingBuffer that keeps the last size events
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{events: make([]Event, max(size, 1))}
+}
+
+// Add implements ReplayBuffer
+func (b *RingBuffer) Add(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events[b.next] = event
+	b.next = (b.next + 1) % len(b.events)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Since implements ReplayBuffer
+func (b *RingBuffer) Since(lastEventID string) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []Event
+	if b.full {
+		events = append(events, b.events[b.next:]...)
+	}
+	events = append(events, b.events[:b.next]...)
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ID == lastEventID {
+			return events[i+1:]
+		}
+	}
+	return events
+}
+
+// EventStream writes Server-Sent Events to a client. Its methods may be called concurrently.
+type EventStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	r      *http.Request
+	replay ReplayBuffer
+	mu     sync.Mutex
+	stop   chan struct{}
+}
+
+// NewEventStream starts a text/event-stream response. If a replay buffer is given, every event with an
+// ID sent on the stream is added to it, and when the client reconnects with a Last-Event-ID header the
+// events it missed are sent first. Until the stream is closed, a keepalive comment is sent every
+// SSEKeepAliveInterval (15 seconds by default), so that proxies do not drop an idle connection. Handlers
+// must call Close before they return.
+func (tools *Tools) NewEventStream(w http.ResponseWriter, r *http.Request, replay ...ReplayBuffer) (*EventStream, error) {
+	s := &EventStream{
+		w:    w,
+		rc:   http.NewResponseController(w),
+		r:    r,
+		stop: make(chan struct{}),
+	}
+	if len(replay) > 0 {
+		s.replay = replay[0]
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Del("Content-Length")
+	// event streams outlive the server's write timeout
+	_ = s.rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if err := s.rc.Flush(); err != nil {
+		return nil, err
+	}
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" && s.replay != nil {
+		for _, event := range s.replay.Since(lastEventID) {
+			b, err := encodeEvent(event)
+			if err == nil {
+				err = s.flush(b)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	go s.keepAlive(cmp.Or(tools.SSEKeepAliveInterval, defaultSSEKeepAliveInterval))
+	return s, nil
+}
+
+// Send sends an event to the client, and adds it to the replay buffer if it has an ID. It returns the
+// context's error once the client has gone away.
+func (s *EventStream) Send(event Event) error {
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(event.ID+event.Event, "\r\n") {
+		return ErrInvalidEvent
+	}
+	b, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+	if s.replay != nil && event.ID != "" {
+		s.replay.Add(event)
+	}
+	return s.flush(b)
+}
+
+// Comment sends a comment, which clients ignore
+func (s *EventStream) Comment(text string) error {
+	if err := s.r.Context().Err(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		buf.WriteString(": " + line + "\n")
+	}
+	buf.WriteByte('\n')
+	return s.flush(buf.Bytes())
+}
+
+// Done returns a channel that is closed when the client goes away
+func (s *EventStream) Done() <-chan struct{} {
+	return s.r.Context().Done()
+}
+
+// Close stops the keepalive comments; nothing is written to the client once it returns
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+// encodeEvent frames an event for the event stream
+func encodeEvent(event Event) ([]byte, error) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if event.ID != "" {
+		buf.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		buf.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	// encoded JSON never contains a raw line break, so the data fits on a single line
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return buf.Bytes(), nil
+}
+
+// flush writes b to the client and flushes it, unless the stream is closed
+func (s *EventStream) flush(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.stop:
+		return ErrEventStreamClosed
+	default:
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// keepAlive sends a keepalive comment every interval until the stream is closed or the client goes away
+func (s *EventStream) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.Comment("keepalive") != nil {
+				return
+			}
+		case <-s.stop:
+			return
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// ServeEvents streams the events received from events to the client until the channel is closed or the
+// client goes away, which is not an error. See NewEventStream for the replay buffer.
+func (tools *Tools) ServeEvents(w http.ResponseWriter, r *http.Request, events <-chan Event, replay ...ReplayBuffer) error {
+	stream, err := tools.NewEventStream(w, r, replay...)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				if r.Context().Err() != nil {
+					return nil
+				}
+				return err
+			}
+		case <-stream.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRingBuffer(t *testing.T) {
+	buffer := NewRingBuffer(3)
+	if events := buffer.Since("1"); len(events) != 0 {
+		t.Errorf("expected an empty buffer, got %v", events)
+	}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		buffer.Add(Event{ID: id})
+	}
+
+	var tests = []struct {
+		lastEventID string
+		expected    string
+	}{
+		{lastEventID: "2", expected: "3 4"},
+		{lastEventID: "4", expected: ""},
+		// event 1 was evicted, so everything that is left is replayed
+		{lastEventID: "1", expected: "2 3 4"},
+		{lastEventID: "unknown", expected: "2 3 4"},
+	}
+	for _, e := range tests {
+		var ids []string
+		for _, event := range buffer.Since(e.lastEventID) {
+			ids = append(ids, event.ID)
+		}
+		if strings.Join(ids, " ") != e.expected {
+			t.Errorf("since %q: expected %q, got %v", e.lastEventID, e.expected, ids)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+
+	stream, err := testTools.NewEventStream(rr, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(Event{ID: "7", Event: "progress", Retry: 3 * time.Second, Data: testEvent{ID: 1, Name: "line\nbreak"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(Event{Data: "done"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Comment("first\nsecond"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(Event{Event: "bad\nname"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("expected ErrInvalidEvent, got %v", err)
+	}
+	if err := stream.Send(Event{Data: make(chan int)}); err == nil {
+		t.Error("expected an error for data that cannot be encoded")
+	}
+	stream.Close()
+	if err := stream.Send(Event{Data: 1}); !errors.Is(err, ErrEventStreamClosed) {
+		t.Errorf("expected ErrEventStreamClosed, got %v", err)
+	}
+
+	if rr.Header().Get("Content-Type") != "text/event-stream" || rr.Header().Get("Cache-Control") != "no-cache" || !rr.Flushed {
+		t.Errorf("unexpected headers %v, flushed %t", rr.Header(), rr.Flushed)
+	}
+	expected := "id: 7\nevent: progress\nretry: 3000\ndata: {\"id\":1,\"name\":\"line\\nbreak\"}\n\n" +
+		"data: \"done\"\n\n" +
+		": first\n: second\n\n"
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+}
+
+func TestEventStream_Replay(t *testing.T) {
+	var testTools Tools
+	buffer := NewRingBuffer(10)
+
+	rr := httptest.NewRecorder()
+	stream, err := testTools.NewEventStream(rr, httptest.NewRequest(http.MethodGet, "/events", nil), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"1", "", "2", "3"} {
+		if err := stream.Send(Event{ID: id, Data: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.Close()
+
+	// a reconnecting client gets the events with an ID it missed
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rr = httptest.NewRecorder()
+	stream, err = testTools.NewEventStream(rr, req, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if rr.Body.String() != "id: 2\ndata: 2\n\nid: 3\ndata: 3\n\n" {
+		t.Errorf("unexpected replay %q", rr.Body.String())
+	}
+}
+
+func TestEventStream_KeepAlive(t *testing.T) {
+	testTools := Tools{SSEKeepAliveInterval: 5 * time.Millisecond}
+	rr := httptest.NewRecorder()
+	stream, err := testTools.NewEventStream(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stream.Close()
+	if !strings.HasPrefix(rr.Body.String(), ": keepalive\n\n") {
+		t.Errorf("expected keepalive comments, got %q", rr.Body.String())
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	var testTools Tools
+	events := make(chan Event, 2)
+	events <- Event{Event: "a", Data: 1}
+	events <- Event{Event: "b", Data: 2}
+	close(events)
+
+	rr := httptest.NewRecorder()
+	if err := testTools.ServeEvents(rr, httptest.NewRequest(http.MethodGet, "/events", nil), events); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body.String() != "event: a\ndata: 1\n\nevent: b\ndata: 2\n\n" {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+
+	// the stream stops without an error when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/events", nil)
+	done := make(chan error)
+	go func() {
+		done <- testTools.ServeEvents(httptest.NewRecorder(), req, make(chan Event))
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error on disconnect, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stream did not stop when the client went away")
+	}
+}
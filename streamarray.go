@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+)
+
+// StreamErrorTrailer is the trailer that reports an error that interrupted a streamed JSON array
+const StreamErrorTrailer = "X-Stream-Error"
+
+// ValuesSeq adapts an iterator that cannot fail to the iterators taken by WriteJSONArray and
+// WriteJSONEnvelope
+func ValuesSeq[T any](seq iter.Seq[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for item := range seq {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// ChanSeq adapts a channel to the iterators taken by WriteJSONArray and WriteJSONEnvelope. Items are
+// received until the channel is closed; then, if errc is given, the error received from it, if any, is
+// reported after them. Iteration stops early if the client goes away, so producers should also watch
+// the context of the request, lest they block on a channel nobody reads anymore.
+func ChanSeq[T any](items <-chan T, errc ...<-chan error) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if len(errc) > 0 {
+			if err := <-errc[0]; err != nil {
+				var zero T
+				yield(zero, err)
+			}
+		}
+	}
+}
+
+// WriteJSONArray writes the items of seq to the client as a single JSON array, encoding and sending each
+// item as it is produced instead of building the whole response in memory. The response is flushed like
+// WriteJSONStream flushes it.
+//
+// If seq fails, or an item cannot be encoded, before anything was sent, nothing is written and the error
+// is returned, so that an error response can still be sent. Once the response has started, the error
+// ends the array with a terminal {"error":true,"message":"..."} object, is reported in the
+// X-Stream-Error trailer as well, and is returned.
+func WriteJSONArray[T any](tools *Tools, w http.ResponseWriter, status int, seq iter.Seq2[T, error]) error {
+	return writeJSONArray(tools, w, status, seq, nil)
+}
+
+// WriteJSONEnvelope is like WriteJSONArray, but wraps the array in a JSONResponse envelope: the items are
+// its data, and the error and message members follow them. An error that interrupts the stream sets
+// error to true and replaces message with its own, besides being reported in the X-Stream-Error trailer.
+func WriteJSONEnvelope[T any](tools *Tools, w http.ResponseWriter, status int, message string, seq iter.Seq2[T, error]) error {
+	return writeJSONArray(tools, w, status, seq, &message)
+}
+
+// writeJSONArray implements WriteJSONArray and, when message is not nil, WriteJSONEnvelope
+func writeJSONArray[T any](tools *Tools, w http.ResponseWriter, status int, seq iter.Seq2[T, error], message *string) error {
+	flusher := newStreamFlusher(tools, w)
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Del("Content-Length")
+		w.Header().Add("Trailer", StreamErrorTrailer)
+		w.WriteHeader(status)
+		open := "["
+		if message != nil {
+			open = `{"data":[`
+		}
+		_, err := io.WriteString(w, open)
+		return err
+	}
+
+	var streamErr error
+	count := 0
+	for item, err := range seq {
+		var out []byte
+		if err == nil {
+			out, err = json.Marshal(item)
+		}
+		if err != nil {
+			if !started {
+				return err
+			}
+			streamErr = err
+			break
+		}
+		if err = start(); err != nil {
+			return err
+		}
+		if count > 0 {
+			out = append([]byte{','}, out...)
+		}
+		if _, err = w.Write(out); err != nil {
+			return err
+		}
+		count++
+		flusher.maybeFlush()
+	}
+	if err := start(); err != nil {
+		return err
+	}
+
+	var tail []byte
+	switch {
+	case message != nil:
+		envelope := JSONResponse{Message: *message}
+		if streamErr != nil {
+			envelope = JSONResponse{Error: true, Message: streamErr.Error()}
+		}
+		out, _ := json.Marshal(envelope)
+		// the envelope members follow the data array
+		tail = append([]byte("],"), out[1:]...)
+	case streamErr != nil:
+		out, _ := json.Marshal(JSONResponse{Error: true, Message: streamErr.Error()})
+		if count > 0 {
+			tail = append(tail, ',')
+		}
+		tail = append(append(tail, out...), ']')
+	default:
+		tail = []byte("]")
+	}
+	if _, err := w.Write(tail); err != nil {
+		return err
+	}
+	if streamErr != nil {
+		w.Header().Set(StreamErrorTrailer, strings.Join(strings.Fields(streamErr.Error()), " "))
+	}
+	flusher.flush()
+	return streamErr
+}
@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// failAfter yields items and then fails with err
+func failAfter[T any](items []T, err error) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+		var zero T
+		yield(zero, err)
+	}
+}
+
+func TestWriteJSONArray(t *testing.T) {
+	var testTools Tools
+	events := []testEvent{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	rr := httptest.NewRecorder()
+	if err := WriteJSONArray(&testTools, rr, http.StatusOK, ValuesSeq(slices.Values(events))); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Header().Get("Content-Type") != "application/json" || !rr.Flushed {
+		t.Errorf("unexpected response %v, flushed %t", rr.Header(), rr.Flushed)
+	}
+	if rr.Body.String() != `[{"id":1,"name":"a"},{"id":2,"name":"b"}]` {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+	if trailer := rr.Result().Trailer.Get(StreamErrorTrailer); trailer != "" {
+		t.Errorf("unexpected trailer %q", trailer)
+	}
+
+	rr = httptest.NewRecorder()
+	if err := WriteJSONArray(&testTools, rr, http.StatusOK, ValuesSeq(slices.Values([]testEvent{}))); err != nil || rr.Body.String() != "[]" {
+		t.Errorf("unexpected response to an empty array: %q %v", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	if err := WriteJSONEnvelope(&testTools, rr, http.StatusCreated, "exported", ValuesSeq(slices.Values(events))); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusCreated || rr.Body.String() != `{"data":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"error":false,"message":"exported"}` {
+		t.Errorf("unexpected envelope %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWriteJSONArray_Errors(t *testing.T) {
+	var testTools Tools
+	failure := errors.New("database\nunavailable")
+
+	rr := httptest.NewRecorder()
+	err := WriteJSONArray(&testTools, rr, http.StatusOK, failAfter([]int{}, failure))
+	if !errors.Is(err, failure) || rr.Body.Len() != 0 || rr.Header().Get("Content-Type") != "" {
+		t.Errorf("expected nothing to be written when the first item fails, got %q (%v)", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	err = WriteJSONArray(&testTools, rr, http.StatusOK, failAfter([]int{1, 2}, failure))
+	if !errors.Is(err, failure) || rr.Body.String() != `[1,2,{"error":true,"message":"database\nunavailable"}]` {
+		t.Errorf("expected a terminal error object, got %q (%v)", rr.Body.String(), err)
+	}
+	if trailer := rr.Result().Trailer.Get(StreamErrorTrailer); trailer != "database unavailable" {
+		t.Errorf("unexpected trailer %q", trailer)
+	}
+
+	rr = httptest.NewRecorder()
+	err = WriteJSONEnvelope(&testTools, rr, http.StatusOK, "exported", failAfter([]int{1}, failure))
+	if !errors.Is(err, failure) || rr.Body.String() != `{"data":[1],"error":true,"message":"database\nunavailable"}` {
+		t.Errorf("expected a failed envelope, got %q (%v)", rr.Body.String(), err)
+	}
+
+	// an item that cannot be encoded interrupts the stream like a failing iterator
+	rr = httptest.NewRecorder()
+	err = WriteJSONArray(&testTools, rr, http.StatusOK, ValuesSeq(slices.Values([]interface{}{1, make(chan int)})))
+	if err == nil || rr.Body.String() != `[1,{"error":true,"message":"json: unsupported type: chan int"}]` {
+		t.Errorf("unexpected response to an unencodable item: %q (%v)", rr.Body.String(), err)
+	}
+}
+
+func TestChanSeq(t *testing.T) {
+	var testTools Tools
+	items := make(chan int)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(items)
+		for i := range 3 {
+			items <- i
+		}
+		errc <- errors.New("producer failed")
+	}()
+
+	rr := httptest.NewRecorder()
+	err := WriteJSONArray(&testTools, rr, http.StatusOK, ChanSeq(items, errc))
+	if err == nil || rr.Body.String() != `[0,1,2,{"error":true,"message":"producer failed"}]` {
+		t.Errorf("unexpected response %q (%v)", rr.Body.String(), err)
+	}
+
+	done := make(chan int, 2)
+	done <- 1
+	done <- 2
+	close(done)
+	rr = httptest.NewRecorder()
+	if err := WriteJSONArray(&testTools, rr, http.StatusOK, ChanSeq(done)); err != nil || rr.Body.String() != "[1,2]" {
+		t.Errorf("unexpected response %q (%v)", rr.Body.String(), err)
+	}
+}
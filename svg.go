@@ -0,0 +1,272 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// SVGMode controls what UploadFiles does with an uploaded SVG that contains unsafe content
+type SVGMode int
+
+const (
+	// SVGSanitize strips unsafe content and stores the cleaned file
+	SVGSanitize SVGMode = iota
+	// SVGReject refuses any SVG that contains unsafe content
+	SVGReject
+)
+
+// UnsafeSVGError is returned when an uploaded SVG cannot be parsed, or contains unsafe
+// content and the SVGMode is SVGReject
+type UnsafeSVGError struct {
+	FileName string
+	Reasons  []string
+}
+
+func (e *UnsafeSVGError) Error() string {
+	if len(e.Reasons) == 0 {
+		return fmt.Sprintf("the uploaded SVG %q is not safe", e.FileName)
+	}
+	return fmt.Sprintf("the uploaded SVG %q is not safe: %s", e.FileName, strings.Join(e.Reasons, "; "))
+}
+
+// svgForbiddenElements are removed together with everything inside them
+var svgForbiddenElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+// svgSafeDataURIs are the only data: URIs allowed in href attributes
+var svgSafeDataURIs = []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"}
+
+// svgCSSComments matches the comments of a style sheet
+var svgCSSComments = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// looksLikeSVG reports whether the sniffed bytes of a text or XML file contain an svg root element
+func looksLikeSVG(buff []byte) bool {
+	return bytes.Contains(bytes.ToLower(buff), []byte("<svg"))
+}
+
+// startsWithSVG reports whether the sniffed bytes of an HTML file are an svg root element behind
+// comments, which make http.DetectContentType report SVG documents as HTML. HTML documents with
+// inline SVG are not matched.
+func startsWithSVG(buff []byte) bool {
+	b := bytes.ToLower(buff)
+	for {
+		b = bytes.TrimLeft(b, "\ufeff \t\r\n")
+		var end []byte
+		switch {
+		case bytes.HasPrefix(b, []byte("<!--")):
+			end = []byte("-->")
+		case bytes.HasPrefix(b, []byte("<?")):
+			end = []byte("?>")
+		case bytes.HasPrefix(b, []byte("<!doctype svg")):
+			end = []byte(">")
+		default:
+			return bytes.HasPrefix(b, []byte("<svg"))
+		}
+		i := bytes.Index(b, end)
+		if i < 0 {
+			return false
+		}
+		b = b[i+len(end):]
+	}
+}
+
+// SanitizeSVG copies the SVG document in src to dst, removing scripts, event handler attributes,
+// javascript: URLs, external references, foreignObject elements and DOCTYPE/entity declarations.
+// External references include CSS url() and @import in style elements and attributes, which are
+// removed as a whole. It returns a description of everything it removed.
+func (tools *Tools) SanitizeSVG(dst io.Writer, src io.Reader) ([]string, error) {
+	var removed []string
+	var out bytes.Buffer
+	dec := xml.NewDecoder(src)
+	dec.Strict = true
+	skipDepth, depth, sawRoot := 0, 0, false
+	// styleStart is where the output of the style element being read starts, or -1
+	styleStart, styleDepth := -1, 0
+	var styleText strings.Builder
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return removed, fmt.Errorf("invalid SVG: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if skipDepth > 0 {
+				continue
+			}
+			local := strings.ToLower(t.Name.Local)
+			if depth == 1 {
+				if local != "svg" {
+					return removed, errors.New("invalid SVG: root element is not svg")
+				}
+				sawRoot = true
+			}
+			if svgForbiddenElements[local] {
+				removed = append(removed, "element <"+t.Name.Local+">")
+				skipDepth = depth
+				continue
+			}
+			if local == "style" && styleStart < 0 {
+				styleStart, styleDepth = out.Len(), depth
+				styleText.Reset()
+			}
+			out.WriteString("<" + svgQualifiedName(t.Name))
+			for _, a := range t.Attr {
+				if reason := svgUnsafeAttr(a); reason != "" {
+					removed = append(removed, reason)
+					continue
+				}
+				out.WriteString(" " + svgQualifiedName(a.Name) + `="`)
+				_ = xml.EscapeText(&out, []byte(a.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+		case xml.EndElement:
+			if skipDepth > 0 {
+				if depth == skipDepth {
+					skipDepth = 0
+				}
+				depth--
+				continue
+			}
+			out.WriteString("</" + svgQualifiedName(t.Name) + ">")
+			if styleStart >= 0 && depth == styleDepth {
+				if reason := svgUnsafeCSS(styleText.String()); reason != "" {
+					removed = append(removed, reason+" in element <"+t.Name.Local+">")
+					out.Truncate(styleStart)
+				}
+				styleStart = -1
+			}
+			depth--
+		case xml.CharData:
+			if skipDepth == 0 && depth > 0 {
+				if styleStart >= 0 {
+					styleText.Write(t)
+				}
+				_ = xml.EscapeText(&out, t)
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" && depth == 0 {
+				out.WriteString("<?xml " + string(t.Inst) + "?>")
+			} else {
+				removed = append(removed, "processing instruction <?"+t.Target+"?>")
+			}
+		case xml.Directive:
+			removed = append(removed, "declaration <!"+firstWord(string(t))+">")
+		case xml.Comment:
+			// comments are dropped silently, they are never rendered
+		}
+	}
+	if !sawRoot {
+		return removed, errors.New("invalid SVG: no svg element found")
+	}
+	if depth != 0 {
+		return removed, errors.New("invalid SVG: unexpected end of document")
+	}
+	_, err := out.WriteTo(dst)
+	return removed, err
+}
+
+// svgUnsafeAttr returns a description of why an attribute must be removed, or an empty string if it is safe
+func svgUnsafeAttr(a xml.Attr) string {
+	name := strings.ToLower(a.Name.Local)
+	value := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, a.Value))
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return "event handler attribute " + svgQualifiedName(a.Name)
+	case strings.Contains(value, "javascript:") || strings.Contains(value, "vbscript:"):
+		return "script URL in attribute " + svgQualifiedName(a.Name)
+	case name == "href" || name == "src":
+		if svgSafeReference(value) {
+			return ""
+		}
+		return "external reference in attribute " + svgQualifiedName(a.Name)
+	case name == "style":
+		if reason := svgUnsafeCSS(a.Value); reason != "" {
+			return reason + " in attribute " + svgQualifiedName(a.Name)
+		}
+	case !svgSafeURLs(value):
+		// presentation attributes such as fill take CSS url() values too
+		return "external reference in attribute " + svgQualifiedName(a.Name)
+	}
+	return ""
+}
+
+// svgUnsafeCSS returns a description of why a style sheet or style attribute must be removed, or an
+// empty string if it is safe. CSS escapes are refused, as they could hide the url or @import keywords.
+func svgUnsafeCSS(css string) string {
+	css = strings.ToLower(strings.Join(strings.Fields(svgCSSComments.ReplaceAllString(css, "")), ""))
+	switch {
+	case strings.Contains(css, `\`):
+		return "escaped CSS"
+	case strings.Contains(css, "@import"):
+		return "CSS @import"
+	case strings.Contains(css, "expression("):
+		return "CSS expression"
+	case !svgSafeURLs(css):
+		return "external CSS url()"
+	}
+	return ""
+}
+
+// svgSafeURLs reports whether every CSS url() in value, which must be lower case without white space,
+// refers to a fragment of the document or to a safe data: URI
+func svgSafeURLs(value string) bool {
+	for rest := value; ; {
+		i := strings.Index(rest, "url(")
+		if i < 0 {
+			return true
+		}
+		rest = rest[i+len("url("):]
+		if !svgSafeReference(strings.TrimLeft(rest, `"'`)) {
+			return false
+		}
+	}
+}
+
+// svgSafeReference reports whether a reference, in lower case without white space, refers to a
+// fragment of the document or to a safe data: URI
+func svgSafeReference(value string) bool {
+	if strings.HasPrefix(value, "#") {
+		return true
+	}
+	for _, prefix := range svgSafeDataURIs {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// svgQualifiedName rebuilds the prefixed name of an element or attribute as it appeared in the source
+func svgQualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func firstWord(s string) string {
+	if f := strings.Fields(s); len(f) > 0 {
+		return f[0]
+	}
+	return ""
+}
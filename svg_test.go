@@ -0,0 +1,176 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unsafeSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)" width="10" height="10">
+  <script>alert(document.cookie)</script>
+  <rect width="10" height="10" fill="red" onclick="steal()"/>
+  <a xlink:href="javascript:alert(1)"><circle r="2"/></a>
+  <image href="https://evil.example.com/track.png"/>
+  <use href="#logo"/>
+  <foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><iframe src="x"></iframe></body></foreignObject>
+</svg>`
+
+const safeSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10" fill="blue"/></svg>`
+
+// newUploadRequest builds a multipart request with a single file in the form field "file"
+func newUploadRequest(t *testing.T, fileName string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/", &body)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestTools_SanitizeSVG(t *testing.T) {
+	var testTools Tools
+	var out bytes.Buffer
+	removed, err := testTools.SanitizeSVG(&out, strings.NewReader(unsafeSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) == 0 {
+		t.Error("expected unsafe content to be reported")
+	}
+	clean := strings.ToLower(out.String())
+	for _, bad := range []string{"<script", "onload", "onclick", "javascript:", "evil.example.com", "foreignobject", "iframe", "doctype", "entity"} {
+		if strings.Contains(clean, bad) {
+			t.Errorf("sanitized SVG still contains %q: %s", bad, out.String())
+		}
+	}
+	for _, good := range []string{`<rect width="10" height="10" fill="red">`, `<use href="#logo">`, `xmlns:xlink=`} {
+		if !strings.Contains(out.String(), good) {
+			t.Errorf("sanitized SVG lost safe content %q: %s", good, out.String())
+		}
+	}
+
+	if _, err = testTools.SanitizeSVG(&out, strings.NewReader("<html><body/></html>")); err == nil {
+		t.Error("expected error for a document that is not an SVG")
+	}
+}
+
+const cssSVG = `<svg xmlns="http://www.w3.org/2000/svg">
+  <style>@import url(https://evil.example.com/x.css); rect { fill: red }</style>
+  <rect style="fill:url(https://evil.example.com/a)" width="1"/>
+  <circle fill=" URL( 'https://evil.example.com/b' )" r="1"/>
+  <style>.a { fill: url(#gradient) }</style>
+  <path style="fill: url(#gradient); stroke: blue" fill="url(&quot;#g&quot;)"/>
+</svg>`
+
+func TestTools_SanitizeSVG_CSS(t *testing.T) {
+	var testTools Tools
+	var out bytes.Buffer
+	removed, err := testTools.SanitizeSVG(&out, strings.NewReader(cssSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 3 {
+		t.Errorf("expected 3 removals, got %q", removed)
+	}
+	if strings.Contains(out.String(), "evil.example.com") || strings.Contains(out.String(), "@import") {
+		t.Errorf("sanitized SVG still references external CSS: %s", out.String())
+	}
+	for _, good := range []string{`<style>.a { fill: url(#gradient) }</style>`, `style="fill: url(#gradient); stroke: blue"`, `<rect width="1">`} {
+		if !strings.Contains(out.String(), good) {
+			t.Errorf("sanitized SVG lost safe content %q: %s", good, out.String())
+		}
+	}
+
+	for _, css := range []string{
+		`<style>@IMPORT "https://evil.example.com/x.css";</style>`,
+		`<style>@im/**/port "x.css";</style>`,
+		`<style>rect { background: u\72l(https://evil.example.com) }</style>`,
+		`<style><![CDATA[rect { fill: url('//evil.example.com/a') }]]></style>`,
+	} {
+		out.Reset()
+		removed, err := testTools.SanitizeSVG(&out, strings.NewReader(`<svg>`+css+`</svg>`))
+		if err != nil || len(removed) != 1 || out.String() != "<svg></svg>" {
+			t.Errorf("%s: expected the style element to be removed, got %q, %q (%v)", css, out.String(), removed, err)
+		}
+	}
+}
+
+var svgUploadTests = []struct {
+	name          string
+	svg           string
+	mode          SVGMode
+	errorExpected bool
+}{
+	{name: "safe svg", svg: safeSVG, mode: SVGReject, errorExpected: false},
+	{name: "unsafe svg sanitized", svg: unsafeSVG, mode: SVGSanitize, errorExpected: false},
+	{name: "unsafe svg rejected", svg: unsafeSVG, mode: SVGReject, errorExpected: true},
+	{name: "external css rejected", svg: `<svg><style>@import url(https://evil.example.com/x.css);</style></svg>`, mode: SVGReject, errorExpected: true},
+	{name: "external style attribute rejected", svg: `<svg><rect style="fill:url(https://evil.example.com/a)"/></svg>`, mode: SVGReject, errorExpected: true},
+	{name: "local css accepted", svg: `<svg><style>rect { fill: url(#g) }</style></svg>`, mode: SVGReject, errorExpected: false},
+	{name: "malformed svg", svg: `<svg><rect></svg>`, mode: SVGSanitize, errorExpected: true},
+	{name: "comment before svg", svg: commentSVG, mode: SVGSanitize, errorExpected: false},
+}
+
+// commentSVG starts with a comment, which http.DetectContentType takes for HTML
+const commentSVG = `<!-- logo --><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script><rect width="10" height="10"/></svg>`
+
+func TestTools_UploadFiles_SVG(t *testing.T) {
+	uploadDir := t.TempDir()
+	for _, e := range svgUploadTests {
+		testTools := Tools{AllowedFileTypes: []string{"image/svg+xml"}, SVGMode: e.mode}
+		files, err := testTools.UploadFiles(newUploadRequest(t, "logo.svg", []byte(e.svg)), uploadDir)
+		if e.errorExpected {
+			var svgErr *UnsafeSVGError
+			if !errors.As(err, &svgErr) {
+				t.Errorf("%s: expected UnsafeSVGError, got %v", e.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+		stored, err := os.ReadFile(filepath.Join(uploadDir, files[0].NewFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(stored), "script") {
+			t.Errorf("%s: stored SVG contains a script: %s", e.name, stored)
+		}
+	}
+
+	// without an allow list, SVG documents are still sanitized, while HTML with inline SVG stays HTML
+	var testTools Tools
+	files, err := testTools.UploadFiles(newUploadRequest(t, "logo.svg", []byte(commentSVG)), uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(filepath.Join(uploadDir, files[0].NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].ContentType != "image/svg+xml" || strings.Contains(string(stored), "script") {
+		t.Errorf("expected a sanitized SVG, got %s: %s", files[0].ContentType, stored)
+	}
+	if fileType := detectContentType([]byte(`<!-- page --><html><body><svg></svg></body></html>`)); !strings.HasPrefix(fileType, "text/html") {
+		t.Errorf("expected HTML with inline SVG to stay HTML, got %s", fileType)
+	}
+}
@@ -0,0 +1,212 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxThrottleChunk is the largest amount of data written or read between two waits on the rate limiters
+const maxThrottleChunk = 32 << 10
+
+// throttleSweepInterval is the least time between two sweeps of the idle per-key rate limiters
+const throttleSweepInterval = time.Minute
+
+// RateLimiter is a token bucket limiting a flow of bytes. It is safe for concurrent use, so a single
+// RateLimiter can be shared by many transfers to cap their combined bandwidth.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond on average, and bursts of up to burst
+// bytes. A burst of zero or less defaults to one second worth of bytes.
+func NewRateLimiter(bytesPerSecond, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &RateLimiter{rate: float64(bytesPerSecond), burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// WaitN blocks until n bytes may pass, or ctx is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	l.last = now
+	// take the tokens now, possibly going into debt, so that concurrent callers queue up behind each other
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// idle reports whether the bucket has refilled by now, so that the limiter holds back nothing and can be
+// replaced by a new one
+func (l *RateLimiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens+now.Sub(l.last).Seconds()*l.rate >= float64(l.burst)
+}
+
+// Throttle caps the bandwidth of downloads or uploads. All the limits that are set apply at once. The
+// limiters of keys whose bucket has refilled are dropped, at most once a minute, so that keys seen once
+// are not kept forever.
+type Throttle struct {
+	// Global is shared by every transfer
+	Global *RateLimiter
+	// PerRequest is the number of bytes per second allowed to each transfer
+	PerRequest int
+	// PerKey is the number of bytes per second allowed to all the transfers sharing a key, such as a user ID
+	PerKey int
+	// Key extracts the key of a request, for PerKey
+	Key func(r *http.Request) string
+
+	mu    sync.Mutex
+	keys  map[string]*RateLimiter
+	swept time.Time
+}
+
+// limiters returns the rate limiters that apply to a transfer for r. A perRequest limit greater than
+// zero overrides PerRequest.
+func (t *Throttle) limiters(r *http.Request, perRequest int) []*RateLimiter {
+	var limiters []*RateLimiter
+	if perRequest <= 0 && t != nil {
+		perRequest = t.PerRequest
+	}
+	if perRequest > 0 {
+		limiters = append(limiters, NewRateLimiter(perRequest, 0))
+	}
+	if t == nil {
+		return limiters
+	}
+	if t.Global != nil {
+		limiters = append(limiters, t.Global)
+	}
+	if t.PerKey > 0 && t.Key != nil {
+		if key := t.Key(r); key != "" {
+			limiters = append(limiters, t.keyLimiter(key))
+		}
+	}
+	return limiters
+}
+
+// keyLimiter returns the rate limiter shared by the transfers of key, dropping idle limiters on the way
+func (t *Throttle) keyLimiter(key string) *RateLimiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.keys == nil {
+		t.keys = make(map[string]*RateLimiter)
+	}
+	if now.Sub(t.swept) >= throttleSweepInterval {
+		for k, l := range t.keys {
+			if l.idle(now) {
+				delete(t.keys, k)
+			}
+		}
+		t.swept = now
+	}
+	l, ok := t.keys[key]
+	if !ok {
+		l = NewRateLimiter(t.PerKey, 0)
+		t.keys[key] = l
+	}
+	return l
+}
+
+// throttleChunk returns the largest chunk that can go through all limiters without exceeding their burst
+func throttleChunk(limiters []*RateLimiter) int {
+	chunk := maxThrottleChunk
+	for _, l := range limiters {
+		chunk = max(min(chunk, l.burst), 1)
+	}
+	return chunk
+}
+
+// waitAll blocks until n bytes may pass every limiter
+func waitAll(ctx context.Context, limiters []*RateLimiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttledWriter is a http.ResponseWriter whose body is written no faster than its limiters allow
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	limiters []*RateLimiter
+	chunk    int
+}
+
+// throttleResponse wraps w when any limiter applies to the request
+func throttleResponse(w http.ResponseWriter, r *http.Request, limiters []*RateLimiter) http.ResponseWriter {
+	if len(limiters) == 0 {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiters: limiters, chunk: throttleChunk(limiters)}
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), tw.chunk)
+		if err := waitAll(tw.ctx, tw.limiters, n); err != nil {
+			return written, err
+		}
+		n, err := tw.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// throttledReader is a reader that reads no faster than its limiters allow
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*RateLimiter
+	chunk    int
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > tr.chunk {
+		p = p[:tr.chunk]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		if waitErr := waitAll(tr.ctx, tr.limiters, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,110 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_WaitN(t *testing.T) {
+	limiter := NewRateLimiter(20000, 1000)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.WaitN(context.Background(), 1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first 1000 bytes are the burst, the other 4000 take 200ms at 20000 bytes per second
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected throttling to take at least 150ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.WaitN(ctx, 100000); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestThrottle_EvictsIdleKeys(t *testing.T) {
+	throttle := &Throttle{PerKey: 1000}
+	alice := throttle.keyLimiter("alice")
+	if err := alice.WaitN(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	_ = throttle.keyLimiter("bob")
+	if throttle.keyLimiter("alice") != alice {
+		t.Error("expected the limiter of a key to be shared")
+	}
+
+	// bob's bucket is full and alice's is drained, so only bob's limiter is dropped on the next sweep
+	throttle.swept = time.Now().Add(-throttleSweepInterval)
+	_ = throttle.keyLimiter("carol")
+	if _, ok := throttle.keys["bob"]; ok {
+		t.Error("expected the idle limiter to be dropped")
+	}
+	if throttle.keys["alice"] != alice {
+		t.Error("expected the busy limiter to be kept")
+	}
+
+	// once refilled, alice's limiter goes too
+	alice.last = alice.last.Add(-2 * time.Second)
+	throttle.swept = time.Now().Add(-throttleSweepInterval)
+	_ = throttle.keyLimiter("carol")
+	if len(throttle.keys) != 1 {
+		t.Errorf("expected only carol to be kept, got %d keys", len(throttle.keys))
+	}
+}
+
+func TestTools_DownloadStaticFile_Throttle(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), make([]byte, 6000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testTool := Tools{DownloadThrottle: &Throttle{
+		PerKey: 1 << 30,
+		Key: func(r *http.Request) string {
+			return r.Header.Get("X-User")
+		},
+	}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "alice")
+	start := time.Now()
+	testTool.DownloadStaticFileWithOptions(w, r, dir, "data.bin", DownloadOptions{BytesPerSecond: 20000})
+	if w.Body.Len() != 6000 {
+		t.Fatalf("expected the whole file, got %d bytes", w.Body.Len())
+	}
+	// one second of burst is allowed, so throttling only shows above 20000 bytes; check the shared
+	// limiter of the key was created, and a slow per-request limit holds the download back
+	if _, ok := testTool.DownloadThrottle.keys["alice"]; !ok {
+		t.Error("expected a limiter for the key")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("download took too long: %s", elapsed)
+	}
+
+	w = httptest.NewRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	testTool.DownloadStaticFileWithOptions(w, r.WithContext(ctx), dir, "data.bin", DownloadOptions{BytesPerSecond: 1000})
+	if w.Body.Len() >= 6000 {
+		t.Errorf("expected the download to be cut short by the context, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestTools_UploadFiles_Throttle(t *testing.T) {
+	testTools := Tools{UploadThrottle: &Throttle{Global: NewRateLimiter(20000, 1000)}}
+	start := time.Now()
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "data.txt", bytes.Repeat([]byte("a"), 5000)), t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected throttling to take at least 150ms, took %s", elapsed)
+	}
+}
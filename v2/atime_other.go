@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package toolkit
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the modification time of a file, as access times are not available on this platform
+func accessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,99 @@
+package toolkit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTools_DownloadStaticFile_AuthorizeAndAudit(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := NewAuditLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTool := Tools{
+		DownloadAuthorizer: func(r *http.Request, name string) (bool, int) {
+			if r.Header.Get("X-User") == "" {
+				return false, http.StatusUnauthorized
+			}
+			return true, 0
+		},
+		DownloadAudit:     auditLog.Record,
+		TrustProxyHeaders: true,
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	testTool.DownloadStaticFile(w, r, "./testdata/pic.jpg", "puppy.jpg")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User", "alice")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	r.Header.Set("Range", "bytes=0-99")
+	testTool.DownloadStaticFile(w, r, "./testdata/pic.jpg", "puppy.jpg")
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected 206, got %d", w.Code)
+	}
+	if err = auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	var records []DownloadRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec DownloadRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(records))
+	}
+	if records[0].Status != http.StatusUnauthorized || records[0].File != "testdata/pic.jpg" || records[0].ClientIP != "192.0.2.1" {
+		t.Errorf("wrong record for the refused download: %+v", records[0])
+	}
+	rec := records[1]
+	if rec.Status != http.StatusPartialContent || rec.BytesSent != 100 || rec.Range != "bytes=0-99" ||
+		rec.ClientIP != "203.0.113.7" || rec.DisplayName != "puppy.jpg" || rec.Duration <= 0 || rec.Time.IsZero() {
+		t.Errorf("wrong record for the download: %+v", rec)
+	}
+}
+
+func TestTools_DownloadZip_Authorize(t *testing.T) {
+	var records []DownloadRecord
+	testTool := Tools{
+		DownloadAuthorizer: func(r *http.Request, name string) (bool, int) {
+			return name != "img.png", http.StatusForbidden
+		},
+		DownloadRoot: "./testdata",
+		DownloadAudit: func(rec DownloadRecord) {
+			records = append(records, rec)
+		},
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	testTool.DownloadZip(w, r, []ZipEntry{{Path: "pic.jpg"}, {Path: "img.png"}}, "all.zip")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+	if len(records) != 1 || records[0].Status != http.StatusForbidden || len(records[0].Files) != 2 {
+		t.Errorf("wrong audit records %+v", records)
+	}
+}
@@ -0,0 +1,303 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// CBORCodec is the Codec for application/cbor (RFC 8949). Values are mapped like encoding/json maps
+// them to JSON, with byte slices as byte strings; tags are ignored when decoding.
+type CBORCodec struct {
+	AllowUnknownFields bool
+}
+
+func (CBORCodec) Name() string        { return "CBOR" }
+func (CBORCodec) ContentType() string { return "application/cbor" }
+
+func (CBORCodec) Encode(w io.Writer, v interface{}) error {
+	e := cborEncoder{buf: new(bytes.Buffer)}
+	if err := marshalValue(e, reflect.ValueOf(v), 0); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+func (c CBORCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := decodeBody(r, decodeCBOR)
+	if err != nil {
+		return err
+	}
+	return decodeInto(v, value, !c.AllowUnknownFields)
+}
+
+// Major types of CBOR data items
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborBreak ends the items of indefinite length data
+const cborBreak = 0xff
+
+type cborEncoder struct {
+	buf *bytes.Buffer
+}
+
+// head writes the initial byte of an item of the major type, with its argument n
+func (e cborEncoder) head(major byte, n uint64) {
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.Write([]byte{major<<5 | 24, byte(n)})
+	case n <= math.MaxUint16:
+		e.buf.Write(binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n)))
+	case n <= math.MaxUint32:
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n)))
+	default:
+		e.buf.Write(binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n))
+	}
+}
+
+func (e cborEncoder) encodeNil() { e.buf.WriteByte(0xf6) }
+
+func (e cborEncoder) encodeBool(b bool) {
+	if b {
+		e.buf.WriteByte(0xf5)
+	} else {
+		e.buf.WriteByte(0xf4)
+	}
+}
+
+func (e cborEncoder) encodeInt(i int64) {
+	if i < 0 {
+		e.head(cborNegInt, uint64(-(i + 1)))
+		return
+	}
+	e.head(cborUint, uint64(i))
+}
+
+func (e cborEncoder) encodeUint(u uint64) { e.head(cborUint, u) }
+
+func (e cborEncoder) encodeFloat(f float64) {
+	if float64(float32(f)) == f {
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{0xfa}, math.Float32bits(float32(f))))
+		return
+	}
+	e.buf.Write(binary.BigEndian.AppendUint64([]byte{0xfb}, math.Float64bits(f)))
+}
+
+func (e cborEncoder) encodeString(s string) {
+	e.head(cborText, uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e cborEncoder) encodeBytes(b []byte) {
+	e.head(cborBytes, uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e cborEncoder) encodeArrayHeader(n int) { e.head(cborArray, uint64(n)) }
+func (e cborEncoder) encodeMapHeader(n int)   { e.head(cborMap, uint64(n)) }
+
+// decodeCBOR reads one CBOR data item as a generic value
+func decodeCBOR(d *byteDecoder, depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, errors.New("CBOR value is nested too deeply")
+	}
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := b>>5, b&0x1f
+	if major == cborSimple {
+		return decodeCBORSimple(d, info)
+	}
+	if info == 31 {
+		return decodeCBORIndefinite(d, major, depth)
+	}
+	n, err := decodeCBORArgument(d, info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return uintValue(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, errors.New("CBOR negative integer out of range")
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		return d.readBytes(n)
+	case cborText:
+		text, err := d.readBytes(n)
+		return string(text), err
+	case cborArray:
+		items := make([]interface{}, 0, capacity(n))
+		for i := uint64(0); i < n; i++ {
+			item, err := decodeCBOR(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		entries := make([]mapEntry, 0, capacity(n))
+		for i := uint64(0); i < n; i++ {
+			entry, err := decodeCBOREntry(d, depth)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	default:
+		// the tag number is ignored, leaving the tagged item
+		return decodeCBOR(d, depth+1)
+	}
+}
+
+// decodeCBORArgument reads the argument of an item whose initial byte has the additional information info
+func decodeCBORArgument(d *byteDecoder, info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return d.readUint(1 << (info - 24))
+	}
+	return 0, fmt.Errorf("malformed CBOR: reserved additional information %d", info)
+}
+
+// decodeCBOREntry reads a key and a value of a map
+func decodeCBOREntry(d *byteDecoder, depth int) (mapEntry, error) {
+	key, err := decodeCBOR(d, depth+1)
+	if err != nil {
+		return mapEntry{}, err
+	}
+	value, err := decodeCBOR(d, depth+1)
+	return mapEntry{key: key, value: value}, err
+}
+
+// decodeCBORSimple reads a simple value or a float
+func decodeCBORSimple(d *byteDecoder, info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		bits, err := d.readUint(2)
+		return halfToFloat(uint16(bits)), err
+	case 26:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 27:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 31:
+		return nil, errors.New("malformed CBOR: unexpected break")
+	}
+	return nil, fmt.Errorf("unsupported CBOR simple value %d", info)
+}
+
+// decodeCBORIndefinite reads the chunks or items of indefinite length data, up to the break
+func decodeCBORIndefinite(d *byteDecoder, major byte, depth int) (interface{}, error) {
+	var chunks []byte
+	var items []interface{}
+	var entries []mapEntry
+	for {
+		next, err := d.r.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if next[0] == cborBreak {
+			_, _ = d.r.ReadByte()
+			break
+		}
+		switch major {
+		case cborBytes, cborText:
+			chunk, err := decodeCBOR(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			switch c := chunk.(type) {
+			case []byte:
+				if major != cborBytes {
+					return nil, errors.New("malformed CBOR: byte string chunk in a text string")
+				}
+				chunks = append(chunks, c...)
+			case string:
+				if major != cborText {
+					return nil, errors.New("malformed CBOR: text string chunk in a byte string")
+				}
+				chunks = append(chunks, c...)
+			default:
+				return nil, errors.New("malformed CBOR: invalid chunk in an indefinite length string")
+			}
+		case cborArray:
+			item, err := decodeCBOR(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		case cborMap:
+			entry, err := decodeCBOREntry(d, depth)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		default:
+			return nil, fmt.Errorf("malformed CBOR: major type %d cannot have an indefinite length", major)
+		}
+	}
+	switch major {
+	case cborBytes:
+		return append([]byte{}, chunks...), nil
+	case cborText:
+		return string(chunks), nil
+	case cborArray:
+		return append([]interface{}{}, items...), nil
+	}
+	return append([]mapEntry{}, entries...), nil
+}
+
+// halfToFloat converts an IEEE 754 half precision float
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,343 @@
+package toolkit
+
+import (
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Codec encodes and decodes values in one media type, for WriteResponse and ReadBody
+type Codec interface {
+	// Name names the format in error messages, such as "JSON"
+	Name() string
+	// ContentType is the media type of the format, such as "application/json"
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+	// Decode reads the single value held by r into v, which must be a pointer. Data after the value
+	// is reported as ErrTrailingData.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// ErrTrailingData is returned by Codec.Decode when data follows the decoded value
+var ErrTrailingData = errors.New("data after the top-level value")
+
+// UnsupportedMediaTypeError is returned by ReadBody when no codec handles the content type of the request
+type UnsupportedMediaTypeError struct {
+	ContentType string
+	Supported   []string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type %q", e.ContentType)
+}
+
+// UnsupportedEncodingError is returned when a request body has a Content-Encoding other than gzip
+type UnsupportedEncodingError struct {
+	Encoding  string
+	Supported []string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+// UnknownFieldError is returned when decoding an object key that matches no field of the target struct
+type UnknownFieldError struct {
+	Key string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.Key)
+}
+
+// DecodeTypeError is returned when a decoded value does not fit the Go value it is decoded into
+type DecodeTypeError struct {
+	// Field is the path of the field, such as "items[2].name", or empty for the top-level value
+	Field string
+	Value string
+	Type  string
+}
+
+func (e *DecodeTypeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("cannot decode %s into a value of type %s", e.Value, e.Type)
+	}
+	return fmt.Sprintf("cannot decode %s into field %q of type %s", e.Value, e.Field, e.Type)
+}
+
+// mediaTypeAliases maps media types that are used interchangeably to the one the codecs declare
+var mediaTypeAliases = map[string]string{
+	"text/xml":                "application/xml",
+	"application/x-msgpack":   "application/msgpack",
+	"application/vnd.msgpack": "application/msgpack",
+}
+
+// JSONCodec is the Codec for application/json
+type JSONCodec struct {
+	AllowUnknownFields bool
+}
+
+func (JSONCodec) Name() string        { return "JSON" }
+func (JSONCodec) ContentType() string { return "application/json" }
+
+func (JSONCodec) Encode(w io.Writer, v interface{}) error {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func (c JSONCodec) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if !c.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// XMLCodec is the Codec for application/xml. It follows the rules of encoding/xml, which cannot
+// encode maps.
+type XMLCodec struct{}
+
+func (XMLCodec) Name() string        { return "XML" }
+func (XMLCodec) ContentType() string { return "application/xml" }
+
+func (XMLCodec) Encode(w io.Writer, v interface{}) error {
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	if err := xml.NewEncoder(&out).Encode(v); err != nil {
+		return err
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func (XMLCodec) Decode(r io.Reader, v interface{}) error {
+	dec := xml.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.Comment, xml.ProcInst:
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return ErrTrailingData
+			}
+		default:
+			return ErrTrailingData
+		}
+	}
+}
+
+// codecs returns the configured codecs, or the built-in ones in order of preference: JSON, XML, CBOR
+// and MessagePack
+func (tools *Tools) codecs() []Codec {
+	if len(tools.Codecs) > 0 {
+		return tools.Codecs
+	}
+	return []Codec{
+		JSONCodec{AllowUnknownFields: tools.AllowUnknownFields},
+		XMLCodec{},
+		CBORCodec{AllowUnknownFields: tools.AllowUnknownFields},
+		MessagePackCodec{AllowUnknownFields: tools.AllowUnknownFields},
+	}
+}
+
+// WriteResponse is like WriteJSON, but encodes data with the codec that best matches the Accept header
+// of r, taking q-values into account. Codecs come from Tools.Codecs, JSON, XML, CBOR and MessagePack by
+// default; the first one is used when the client accepts none of them. With CompressResponseMinSize set,
+// bodies of at least that many bytes are compressed as the Accept-Encoding header of r allows.
+func (tools *Tools) WriteResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers ...http.Header) error {
+	codecs := tools.codecs()
+	offered := make([]string, len(codecs))
+	for i, codec := range codecs {
+		offered[i] = codec.ContentType()
+	}
+	codec := codecs[0]
+	if contentType := negotiateMediaType(r.Header.Get("Accept"), offered...); contentType != "" {
+		for _, c := range codecs {
+			if c.ContentType() == contentType {
+				codec = c
+				break
+			}
+		}
+	}
+	var out bytes.Buffer
+	if err := codec.Encode(&out, data); err != nil {
+		return err
+	}
+	if len(headers) > 0 {
+		for k, v := range headers[0] {
+			w.Header()[k] = v
+		}
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Header().Add("Vary", "Accept")
+	body := tools.compressBody(w, r, out.Bytes())
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// ReadBody is like ReadJSON, but decodes the body with the codec matching its Content-Type, with the
+// same size limit and error messages. A body without a Content-Type is decoded with the first codec;
+// one with a type that no codec handles gets an UnsupportedMediaTypeError. Structured syntax suffixes,
+// as in application/vnd.api+json, are matched against the codecs for their base format.
+func (tools *Tools) ReadBody(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	codecs := tools.codecs()
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		return tools.readBody(w, r, codecs[0], data)
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err == nil {
+		mediaType = cmp.Or(mediaTypeAliases[mediaType], mediaType)
+		_, suffix, _ := strings.Cut(mediaType, "+")
+		for _, codec := range codecs {
+			if codec.ContentType() == mediaType || (suffix != "" && codec.ContentType() == "application/"+suffix) {
+				return tools.readBody(w, r, codec, data)
+			}
+		}
+	}
+	supported := make([]string, len(codecs))
+	for i, codec := range codecs {
+		supported[i] = codec.ContentType()
+	}
+	return &UnsupportedMediaTypeError{ContentType: header, Supported: supported}
+}
+
+// readBody decodes the body of r into data with codec, limiting its size to MaxJSONSize and turning
+// decoding errors into messages fit for the client. Gzip-encoded bodies are decompressed, and the limit
+// applies to the decompressed body.
+func (tools *Tools) readBody(w http.ResponseWriter, r *http.Request, codec Codec, data interface{}) error {
+	maxBytes := 1 << 20
+	if tools.MaxJSONSize != 0 {
+		maxBytes = tools.MaxJSONSize
+	}
+	body, err := decodedBody(r)
+	if err != nil {
+		return err
+	}
+	r.Body = http.MaxBytesReader(w, body, int64(maxBytes))
+	return decodeError(codec.Decode(r.Body, data), codec.Name(), maxBytes)
+}
+
+// gzipBody is a decompressed request body, which closes the original body as well
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	return errors.Join(b.Reader.Close(), b.body.Close())
+}
+
+// decodedBody returns the body of r with its Content-Encoding, which may only be gzip, removed
+func decodedBody(r *http.Request) (io.ReadCloser, error) {
+	encoding := r.Header.Get("Content-Encoding")
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err == io.EOF {
+			return nil, errors.New("body must not be empty")
+		}
+		if err != nil {
+			return nil, errors.New("body contains badly-formed gzip data")
+		}
+		return gzipBody{Reader: zr, body: r.Body}, nil
+	default:
+		return nil, &UnsupportedEncodingError{Encoding: encoding, Supported: []string{"gzip"}}
+	}
+}
+
+// checkJSONContentType returns an UnsupportedMediaTypeError unless r has a JSON Content-Type:
+// application/json, or a type with the +json structured syntax suffix such as application/problem+json,
+// with no charset other than UTF-8
+func checkJSONContentType(r *http.Request) error {
+	header := r.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		charset, set := params["charset"]
+		if !set || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8") {
+			return nil
+		}
+	}
+	return &UnsupportedMediaTypeError{ContentType: header, Supported: []string{"application/json"}}
+}
+
+// decodeError turns an error decoding a value in the format name, limited to maxBytes, into a message
+// fit for the client
+func decodeError(err error, name string, maxBytes int) error {
+	if err == nil {
+		return nil
+	}
+	var maxBytesError *http.MaxBytesError
+	var syntaxError *json.SyntaxError
+	var xmlSyntaxError *xml.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var decodeTypeError *DecodeTypeError
+	var unknownFieldError *UnknownFieldError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+	var corruptInputError flate.CorruptInputError
+	switch {
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.As(err, &corruptInputError):
+		return errors.New("body contains badly-formed gzip data")
+	case errors.Is(err, ErrTrailingData):
+		return fmt.Errorf("body must contain only one %s value", name)
+	case errors.As(err, &maxBytesError):
+		return &BodyTooLargeError{Limit: int64(maxBytes)}
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed %s (at character %d)", name, syntaxError.Offset)
+	case errors.As(err, &xmlSyntaxError):
+		return fmt.Errorf("body contains badly-formed %s (at line %d)", name, xmlSyntaxError.Line)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("body contains badly-formed %s", name)
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect %s type for field %q", name, unmarshalTypeError.Field)
+		}
+		return fmt.Errorf("body contains incorrect %s type (at character %d)", name, unmarshalTypeError.Offset)
+	case errors.As(err, &decodeTypeError):
+		if decodeTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect %s type for field %q", name, decodeTypeError.Field)
+		}
+		return fmt.Errorf("body contains incorrect %s type", name)
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("body contains unknown key %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	case errors.As(err, &unknownFieldError):
+		return fmt.Errorf("body contains unknown key %q", unknownFieldError.Key)
+	case errors.As(err, &invalidUnmarshalError):
+		return fmt.Errorf("error unmarshaling %s: %s", name, invalidUnmarshalError.Error())
+	default:
+		return err
+	}
+}
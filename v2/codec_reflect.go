@@ -0,0 +1,604 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The CBOR and MessagePack codecs share the reflection code in this file. Go values are walked like
+// encoding/json does, following json struct tags and the json.Marshaler and encoding.TextMarshaler
+// interfaces, and handed to a format specific valueEncoder. Decoding goes the other way: the format
+// specific decoder builds a tree of generic values, which unmarshalValue assigns to the target.
+
+// maxCodecDepth bounds the nesting of encoded and decoded values, guarding against cycles and
+// against bodies crafted to exhaust the stack
+const maxCodecDepth = 1000
+
+// valueEncoder writes the items of a binary format
+type valueEncoder interface {
+	encodeNil()
+	encodeBool(b bool)
+	encodeInt(i int64)
+	encodeUint(u uint64)
+	encodeFloat(f float64)
+	encodeString(s string)
+	encodeBytes(b []byte)
+	encodeArrayHeader(n int)
+	encodeMapHeader(n int)
+}
+
+// mapEntry is a key and value of a decoded map. Generic values are nil, bool, int64, uint64 (only
+// above math.MaxInt64), float64, string, []byte, []interface{}, []mapEntry and time.Time.
+type mapEntry struct {
+	key, value interface{}
+}
+
+// codecField is a struct field as seen by the codecs
+type codecField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var codecFieldCache sync.Map
+
+// codecFields lists the fields of the struct type t under their JSON names, promoting the fields of
+// embedded structs like encoding/json does. Outer fields hide embedded fields with the same name.
+func codecFields(t reflect.Type) []codecField {
+	if fields, ok := codecFieldCache.Load(t); ok {
+		return fields.([]codecField)
+	}
+	var fields []codecField
+	collectCodecFields(t, nil, &fields, map[reflect.Type]bool{t: true})
+	sort.SliceStable(fields, func(i, j int) bool {
+		return len(fields[i].index) < len(fields[j].index)
+	})
+	seen := make(map[string]bool)
+	kept := fields[:0]
+	for _, f := range fields {
+		if !seen[f.name] {
+			seen[f.name] = true
+			kept = append(kept, f)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return slices.Compare(kept[i].index, kept[j].index) < 0
+	})
+	codecFieldCache.Store(t, kept)
+	return kept
+}
+
+func collectCodecFields(t reflect.Type, index []int, fields *[]codecField, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(slices.Clone(index), i)
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !visiting[ft] {
+					visiting[ft] = true
+					collectCodecFields(ft, fieldIndex, fields, visiting)
+					delete(visiting, ft)
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		*fields = append(*fields, codecField{
+			name:      cmp.Or(name, f.Name),
+			index:     fieldIndex,
+			omitEmpty: slices.Contains(strings.Split(opts, ","), "omitempty"),
+		})
+	}
+}
+
+// isEmptyValue reports whether v is empty for the omitempty option
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+var (
+	jsonMarshalerType   = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	timeType            = reflect.TypeFor[time.Time]()
+)
+
+// marshalValue encodes v with e
+func marshalValue(e valueEncoder, v reflect.Value, depth int) error {
+	if depth > maxCodecDepth {
+		return errors.New("value is nested too deeply")
+	}
+	if !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil()) {
+		e.encodeNil()
+		return nil
+	}
+	if v.CanInterface() {
+		if n, ok := v.Interface().(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				e.encodeInt(i)
+				return nil
+			}
+			f, err := n.Float64()
+			if err != nil {
+				return err
+			}
+			e.encodeFloat(f)
+			return nil
+		}
+		switch {
+		case v.Type().Implements(jsonMarshalerType):
+			data, err := v.Interface().(json.Marshaler).MarshalJSON()
+			if err != nil {
+				return err
+			}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			var generic interface{}
+			if err = dec.Decode(&generic); err != nil {
+				return err
+			}
+			return marshalValue(e, reflect.ValueOf(generic), depth+1)
+		case v.Type().Implements(textMarshalerType):
+			text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return err
+			}
+			e.encodeString(string(text))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		e.encodeBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		e.encodeFloat(v.Float())
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.encodeNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		fallthrough
+	case reflect.Array:
+		e.encodeArrayHeader(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if err := marshalValue(e, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.encodeNil()
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		e.encodeMapHeader(len(keys))
+		for _, k := range keys {
+			if err := marshalValue(e, k, depth+1); err != nil {
+				return err
+			}
+			if err := marshalValue(e, v.MapIndex(k), depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		type present struct {
+			name  string
+			value reflect.Value
+		}
+		var fields []present
+		for _, f := range codecFields(v.Type()) {
+			fv, ok := fieldByIndex(v, f.index, false)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			fields = append(fields, present{name: f.name, value: fv})
+		}
+		e.encodeMapHeader(len(fields))
+		for _, f := range fields {
+			e.encodeString(f.name)
+			if err := marshalValue(e, f.value, depth+1); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer, reflect.Interface:
+		return marshalValue(e, v.Elem(), depth+1)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// fieldByIndex returns the field of the struct v at index, going through embedded pointers. When
+// allocate is set, nil embedded pointers are allocated; otherwise the field is reported missing.
+func fieldByIndex(v reflect.Value, index []int, allocate bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !allocate || !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// decodeInto assigns the generic value decoded from a body to v, which must be a non-nil pointer
+func decodeInto(v interface{}, value interface{}, strict bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return &json.InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	return unmarshalValue(rv.Elem(), value, "", strict)
+}
+
+// genericKind names the kind of a generic value in error messages
+func genericKind(src interface{}) string {
+	switch src.(type) {
+	case bool:
+		return "boolean"
+	case int64, uint64, float64:
+		return "number"
+	case string:
+		return "string"
+	case []byte:
+		return "byte string"
+	case []interface{}:
+		return "array"
+	case []mapEntry:
+		return "map"
+	case time.Time:
+		return "timestamp"
+	}
+	return fmt.Sprintf("%T", src)
+}
+
+// unmarshalValue assigns the generic value src to dst, whose path is path. When strict is set, map
+// keys that match no field of a struct are reported as an UnknownFieldError.
+func unmarshalValue(dst reflect.Value, src interface{}, path string, strict bool) error {
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			dst.SetZero()
+		}
+		return nil
+	}
+	if dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return unmarshalValue(dst.Elem(), src, path, strict)
+	}
+	typeError := &DecodeTypeError{Field: path, Value: genericKind(src), Type: dst.Type().String()}
+	if t, ok := src.(time.Time); ok && dst.Type() == timeType {
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if dst.CanAddr() && dst.Kind() != reflect.Interface {
+		switch addr := dst.Addr(); {
+		case addr.Type().Implements(jsonUnmarshalerType):
+			data, err := json.Marshal(jsonCompatible(src))
+			if err != nil {
+				return err
+			}
+			return addr.Interface().(json.Unmarshaler).UnmarshalJSON(data)
+		case addr.Type().Implements(textUnmarshalerType):
+			s, ok := src.(string)
+			if !ok {
+				return typeError
+			}
+			return addr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return typeError
+		}
+		dst.Set(reflect.ValueOf(jsonCompatible(src)))
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return typeError
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := src.(int64)
+		if !ok || dst.OverflowInt(i) {
+			return typeError
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := src.(type) {
+		case int64:
+			if n < 0 {
+				return typeError
+			}
+			u = uint64(n)
+		case uint64:
+			u = n
+		default:
+			return typeError
+		}
+		if dst.OverflowUint(u) {
+			return typeError
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := src.(type) {
+		case int64:
+			f = float64(n)
+		case uint64:
+			f = float64(n)
+		case float64:
+			f = n
+		default:
+			return typeError
+		}
+		if dst.OverflowFloat(f) {
+			return typeError
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		switch s := src.(type) {
+		case string:
+			dst.SetString(s)
+		case []byte:
+			dst.SetString(string(s))
+		default:
+			return typeError
+		}
+	case reflect.Slice:
+		if b, ok := src.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes(slices.Clone(b))
+			return nil
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return typeError
+		}
+		s := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := unmarshalValue(s.Index(i), item, fmt.Sprintf("%s[%d]", path, i), strict); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		items, ok := src.([]interface{})
+		if !ok {
+			return typeError
+		}
+		for i := 0; i < dst.Len(); i++ {
+			if i >= len(items) {
+				dst.Index(i).SetZero()
+				continue
+			}
+			if err := unmarshalValue(dst.Index(i), items[i], fmt.Sprintf("%s[%d]", path, i), strict); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		entries, ok := src.([]mapEntry)
+		if !ok {
+			return typeError
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMapWithSize(dst.Type(), len(entries)))
+		}
+		for _, entry := range entries {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := unmarshalMapKey(key, entry.key, path, strict); err != nil {
+				return err
+			}
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := unmarshalValue(value, entry.value, joinFieldPath(path, fmt.Sprint(entry.key)), strict); err != nil {
+				return err
+			}
+			dst.SetMapIndex(key, value)
+		}
+	case reflect.Struct:
+		entries, ok := src.([]mapEntry)
+		if !ok {
+			return typeError
+		}
+		fields := codecFields(dst.Type())
+		for _, entry := range entries {
+			name, _ := entry.key.(string)
+			i := slices.IndexFunc(fields, func(f codecField) bool {
+				return f.name == name
+			})
+			if i < 0 {
+				i = slices.IndexFunc(fields, func(f codecField) bool {
+					return strings.EqualFold(f.name, name)
+				})
+			}
+			if i < 0 {
+				if strict {
+					return &UnknownFieldError{Key: fmt.Sprint(entry.key)}
+				}
+				continue
+			}
+			fieldPath := joinFieldPath(path, fields[i].name)
+			field, ok := fieldByIndex(dst, fields[i].index, true)
+			if !ok {
+				return &DecodeTypeError{Field: fieldPath, Value: genericKind(entry.value), Type: "unexported embedded pointer"}
+			}
+			if err := unmarshalValue(field, entry.value, fieldPath, strict); err != nil {
+				return err
+			}
+		}
+	default:
+		return typeError
+	}
+	return nil
+}
+
+// unmarshalMapKey assigns a decoded map key to key, converting strings to integers for maps with
+// integer keys, as encoding/json does
+func unmarshalMapKey(key reflect.Value, src interface{}, path string, strict bool) error {
+	s, ok := src.(string)
+	if !ok {
+		return unmarshalValue(key, src, path, strict)
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			src = i
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			src = u
+		}
+	}
+	return unmarshalValue(key, src, path, strict)
+}
+
+// jsonCompatible turns a generic value into the values encoding/json produces for interface{} targets:
+// maps become map[string]interface{}, with keys formatted as strings
+func jsonCompatible(src interface{}) interface{} {
+	switch v := src.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonCompatible(item)
+		}
+		return items
+	case []mapEntry:
+		m := make(map[string]interface{}, len(v))
+		for _, entry := range v {
+			m[fmt.Sprint(entry.key)] = jsonCompatible(entry.value)
+		}
+		return m
+	}
+	return src
+}
+
+// byteDecoder reads the items of a binary format
+type byteDecoder struct {
+	r *bufio.Reader
+}
+
+// readByte reads one byte, reporting the end of the input as io.ErrUnexpectedEOF
+func (d *byteDecoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+// readUint reads a big endian unsigned integer of size bytes
+func (d *byteDecoder) readUint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[8-size:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// readBytes reads n bytes. Memory is allocated as the bytes arrive, so that a forged length cannot
+// allocate more than the body holds.
+func (d *byteDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, errors.New("length too large")
+	}
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// decodeBody runs decode, which reads one generic value with d, and checks that nothing follows it
+func decodeBody(r io.Reader, decode func(d *byteDecoder, depth int) (interface{}, error)) (interface{}, error) {
+	d := &byteDecoder{r: bufio.NewReader(r)}
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	value, err := decode(d, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch _, err = d.r.ReadByte(); {
+	case err == nil:
+		return nil, ErrTrailingData
+	case err != io.EOF:
+		return nil, err
+	}
+	return value, nil
+}
+
+// capacity bounds the capacity preallocated for n items announced by a body
+func capacity(n uint64) int {
+	return int(min(n, 1024))
+}
+
+// uintValue returns the generic value of an unsigned integer: int64 when it fits, uint64 otherwise
+func uintValue(u uint64) interface{} {
+	if u > math.MaxInt64 {
+		return u
+	}
+	return int64(u)
+}
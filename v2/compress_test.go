@@ -0,0 +1,218 @@
+package toolkit
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// decodeResponseBody returns the body of res, decompressed according to its Content-Encoding
+func decodeResponseBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	var r io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "deflate":
+		r = flate.NewReader(res.Body)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+var compressJSONTests = []struct {
+	name           string
+	acceptEncoding string
+	items          int
+	expectEncoding string
+}{
+	{name: "gzip", acceptEncoding: "gzip, deflate", items: 100, expectEncoding: "gzip"},
+	{name: "deflate", acceptEncoding: "deflate", items: 100, expectEncoding: "deflate"},
+	{name: "below threshold", acceptEncoding: "gzip", items: 2},
+	{name: "not accepted", acceptEncoding: "br", items: 100},
+	{name: "no header", items: 100},
+}
+
+func TestTools_WriteJSON_Compress(t *testing.T) {
+	testTools := Tools{CompressResponseMinSize: 256}
+	for _, e := range compressJSONTests {
+		payload := JSONResponse{Message: "list", Data: strings.Split(strings.Repeat("item,", e.items), ",")}
+		handler := testTools.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = testTools.WriteJSON(w, http.StatusOK, payload)
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if e.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", e.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		res := rr.Result()
+		if res.Header.Get("Content-Encoding") != e.expectEncoding {
+			t.Errorf("%s: expected encoding %q, got %q", e.name, e.expectEncoding, res.Header.Get("Content-Encoding"))
+		}
+		if res.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: wrong Vary %q", e.name, res.Header.Get("Vary"))
+		}
+		if res.Header.Get("Content-Length") != strconv.Itoa(rr.Body.Len()) {
+			t.Errorf("%s: Content-Length %s does not match the body of %d bytes", e.name, res.Header.Get("Content-Length"), rr.Body.Len())
+		}
+		if body := decodeResponseBody(t, res); !strings.HasPrefix(body, `{"error":false,"message":"list"`) {
+			t.Errorf("%s: unexpected body %.40s", e.name, body)
+		}
+	}
+}
+
+func TestTools_ErrorJSON_Compress(t *testing.T) {
+	testTools := Tools{}
+	handler := testTools.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.ErrorJSON(w, errors.New(strings.Repeat("very long error ", 100)), http.StatusTeapot)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	if rr.Code != http.StatusTeapot || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a gzipped 418, got %d %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestTools_WriteJSONRequest_Compress(t *testing.T) {
+	testTools := Tools{CompressResponseMinSize: 256}
+	for _, e := range compressJSONTests {
+		payload := JSONResponse{Message: "list", Data: strings.Split(strings.Repeat("item,", e.items), ",")}
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if e.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", e.acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		if err := testTools.WriteJSONRequest(rr, r, http.StatusOK, payload); err != nil {
+			t.Fatal(err)
+		}
+		res := rr.Result()
+		if res.Header.Get("Content-Encoding") != e.expectEncoding {
+			t.Errorf("%s: expected encoding %q, got %q", e.name, e.expectEncoding, res.Header.Get("Content-Encoding"))
+		}
+		if res.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%s: wrong Vary %q", e.name, res.Header.Get("Vary"))
+		}
+		if body := decodeResponseBody(t, res); !strings.HasPrefix(body, `{"error":false,"message":"list"`) {
+			t.Errorf("%s: unexpected body %.40s", e.name, body)
+		}
+	}
+
+	// without the request, WriteJSON cannot tell what the client accepts
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	_ = testTools.WriteJSON(rr, http.StatusOK, JSONResponse{Message: strings.Repeat("long message ", 100)})
+	if rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected an uncompressed response, got %q", rr.Header().Get("Content-Encoding"))
+	}
+
+	rr = httptest.NewRecorder()
+	_ = testTools.ErrorJSONRequest(rr, r, errors.New(strings.Repeat("very long error ", 100)), http.StatusTeapot)
+	if rr.Code != http.StatusTeapot || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected a gzipped 418, got %d %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestTools_WriteResponse_Compress(t *testing.T) {
+	testTools := Tools{CompressResponseMinSize: 100}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Accept", "application/xml")
+	rr := httptest.NewRecorder()
+	type list struct {
+		Items []string `xml:"item"`
+	}
+	data := list{Items: strings.Split(strings.Repeat("item,", 50), ",")}
+	if err := testTools.WriteResponse(rr, r, http.StatusOK, data); err != nil {
+		t.Fatal(err)
+	}
+	res := rr.Result()
+	if res.Header.Get("Content-Encoding") != "gzip" || res.Header.Values("Vary")[1] != "Accept-Encoding" {
+		t.Errorf("expected a gzipped response, got %v", res.Header)
+	}
+	if body := decodeResponseBody(t, res); !strings.Contains(body, "<item>item</item>") {
+		t.Errorf("unexpected body %.60s", body)
+	}
+}
+
+func TestTools_Compress(t *testing.T) {
+	var testTools Tools
+	large := strings.Repeat("hello, world\n", 200)
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectEncoding string
+		expectLength   bool
+		expectBody     string
+	}{
+		{name: "large text", expectEncoding: "gzip", expectBody: large, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			for _, line := range strings.SplitAfter(large, "\n") {
+				_, _ = io.WriteString(w, line)
+			}
+		}},
+		{name: "small text", expectLength: true, expectBody: "hello", handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello")
+		}},
+		{name: "image", expectBody: large, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, large)
+		}},
+		{name: "already encoded", expectEncoding: "br", expectBody: large, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(w, large)
+		}},
+		{name: "partial", expectBody: large, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, large)
+		}},
+		{name: "flushed stream", expectBody: "data: 1\n\n" + large, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			_, _ = io.WriteString(w, large)
+		}},
+		{name: "no body", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}},
+	}
+	for _, e := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		testTools.Compress(e.handler).ServeHTTP(rr, r)
+		res := rr.Result()
+		if res.Header.Get("Content-Encoding") != e.expectEncoding {
+			t.Errorf("%s: expected encoding %q, got %q", e.name, e.expectEncoding, res.Header.Get("Content-Encoding"))
+		}
+		if e.expectLength && res.Header.Get("Content-Length") != strconv.Itoa(len(e.expectBody)) {
+			t.Errorf("%s: wrong Content-Length %q", e.name, res.Header.Get("Content-Length"))
+		}
+		body := rr.Body.String()
+		if e.expectEncoding == "gzip" {
+			body = decodeResponseBody(t, res)
+			if res.Header.Get("Content-Length") != "" || res.Header.Get("ETag") != `W/"v1"` {
+				t.Errorf("%s: unexpected headers %v", e.name, res.Header)
+			}
+		}
+		if body != e.expectBody {
+			t.Errorf("%s: unexpected body %.40q", e.name, body)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package toolkit
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadPath turns a requested file name into a path inside the download root. It rejects
+// anything that would leave the root, hidden files and directories, and metadata sidecars.
+func downloadPath(name string) (string, bool) {
+	name = path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(name) || name == "." || isMetadataFile(name) {
+		return "", false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// DownloadOptions customises how a file is served
+type DownloadOptions struct {
+	// DisplayName is the file name suggested to the client. If it is empty, the original file name
+	// is taken from the metadata sidecar of the file, or the stored name is used.
+	DisplayName string
+	// Inline asks the browser to display the file instead of saving it
+	Inline bool
+	// ContentType overrides the content type otherwise taken from the metadata sidecar or guessed
+	// from the file extension
+	ContentType string
+	// CacheControl overrides Tools.CacheControl for this download
+	CacheControl string
+	// BytesPerSecond, if greater than zero, caps the bandwidth of this download, overriding the
+	// PerRequest limit of Tools.DownloadThrottle
+	BytesPerSecond int
+}
+
+// contentDisposition builds a Content-Disposition header following RFC 6266: an ASCII-only filename
+// parameter for older clients, and an RFC 5987 encoded filename* parameter when the name cannot be
+// represented in ASCII as is
+func contentDisposition(inline bool, name string) string {
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+	}
+	name = strings.ToValidUTF8(name, "_")
+	var fallback strings.Builder
+	for _, c := range name {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			// control characters, including CR and LF, would break the header
+		case c > 0x7e || c == '"' || c == '\\':
+			fallback.WriteByte('_')
+		default:
+			fallback.WriteRune(c)
+		}
+	}
+	if fallback.Len() == 0 {
+		return disposition
+	}
+	header := disposition + `; filename="` + fallback.String() + `"`
+	if fallback.String() != name {
+		header += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return header
+}
+
+// encodeRFC5987 percent-encodes every byte of s that is not an attr-char as defined by RFC 5987
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+// DownloadFS sends the file name from fsys, such as an embed.FS, to the client, like DownloadStaticFile
+// does for files on disk. Files that implement io.Seeker support range and conditional requests; others
+// are streamed in full. With Precompressed set, a .br, .zst or .gz sibling of the file is sent instead
+// when the client accepts its encoding; with CompressMinSize set, compressible files of at least that
+// many bytes without such a sibling are gzipped on the fly. Anything that cannot be served, whether it
+// is missing, outside of fsys, hidden or unreadable, gets the same 404 response so that the existence
+// of files is not leaked.
+func (tools *Tools) DownloadFS(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, opts DownloadOptions) {
+	tools.serveFile(w, r, fsys, fsys, name, opts)
+}
+
+// serveFile implements DownloadFS. The identity of fsys is used to cache ETags, and must stay the same
+// across requests for the same files.
+func (tools *Tools) serveFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, identity any, name string, opts DownloadOptions) {
+	w, audit := tools.startAudit(w, r)
+	rec := DownloadRecord{File: name}
+	defer func() {
+		audit(rec)
+	}()
+	name, ok := downloadPath(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	rec.File = name
+	if !tools.authorize(w, r, name) {
+		return
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func(f fs.File) {
+		_ = f.Close()
+	}(f)
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	displayName, contentType := opts.DisplayName, opts.ContentType
+	var meta *FileMetadata
+	if displayName == "" || contentType == "" || tools.ETags {
+		if meta, err = readMetadataFS(fsys, name); err == nil {
+			if displayName == "" {
+				displayName = meta.OriginalFileName
+			}
+			if contentType == "" {
+				contentType = meta.ContentType
+			}
+		}
+	}
+	var etag string
+	if tools.ETags {
+		if etag, err = contentETag(fsys, identity, name, info, meta); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if cacheControl := cmp.Or(opts.CacheControl, tools.CacheControl); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if displayName == "" {
+		displayName = path.Base(name)
+	}
+	rec.DisplayName = displayName
+	if contentType == "" && (tools.Precompressed || tools.CompressMinSize > 0) {
+		// encoded content cannot be sniffed, so the type has to come from the name
+		contentType = cmp.Or(mime.TypeByExtension(path.Ext(name)), "application/octet-stream")
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(opts.Inline, displayName))
+
+	// content codings: a precompressed sibling if the client accepts one, or gzip on the fly for
+	// compressible files, unless the client asks for a range of the file itself
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	if tools.Precompressed || tools.CompressMinSize > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	gzipped := false
+	if tools.Precompressed {
+		if encoding, encoded, encodedInfo := openPrecompressed(fsys, name, acceptEncoding); encoded != nil {
+			defer func(encoded fs.File) {
+				_ = encoded.Close()
+			}(encoded)
+			f, info = encoded, encodedInfo
+			w.Header().Set("Content-Encoding", encoding)
+			etag = encodedETag(etag, encoding)
+		}
+	}
+	if w.Header().Get("Content-Encoding") == "" && tools.CompressMinSize > 0 && info.Size() >= tools.CompressMinSize &&
+		r.Header.Get("Range") == "" && compressible(contentType) && negotiateEncoding(acceptEncoding, "gzip") == "gzip" {
+		gzipped = true
+		etag = encodedETag(etag, "gzip")
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	w = throttleResponse(w, r, tools.DownloadThrottle.limiters(r, opts.BytesPerSecond))
+	if content, ok := f.(io.ReadSeeker); ok && !gzipped {
+		http.ServeContent(w, r, name, info.ModTime(), content)
+		return
+	}
+	serveStream(w, r, name, info, f, gzipped)
+}
+
+// serveStream sends a file that cannot seek, or that is gzipped on the fly. Range requests are not
+// possible, so the whole file is always sent, but the response still has a content type, a length
+// unless it is gzipped, and supports conditional requests on its ETag and modification time.
+func serveStream(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo, content io.Reader, gzipped bool) {
+	modTime := info.ModTime()
+	hasModTime := !modTime.IsZero() && !modTime.Equal(time.Unix(0, 0))
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		notModified := false
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			notModified = etagMatches(inm, w.Header().Get("ETag"))
+		} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && hasModTime {
+			notModified = !modTime.Truncate(time.Second).After(ims)
+		}
+		if notModified {
+			h := w.Header()
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			h.Del("Content-Disposition")
+			h.Del("Content-Encoding")
+			if hasModTime {
+				h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if hasModTime {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	br := bufio.NewReaderSize(content, 512)
+	if w.Header().Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			sniffed, _ := br.Peek(512)
+			contentType = http.DetectContentType(sniffed)
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	if !gzipped {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = io.CopyN(w, br, info.Size())
+		}
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		zw := gzip.NewWriter(w)
+		if _, err := io.CopyN(zw, br, info.Size()); err == nil {
+			_ = zw.Close()
+		}
+	}
+}
+
+// readMetadataFS reads the sidecar of the file name in fsys
+func readMetadataFS(fsys fs.FS, name string) (*FileMetadata, error) {
+	data, err := fs.ReadFile(fsys, name+MetadataSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var meta FileMetadata
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
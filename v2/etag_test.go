@@ -0,0 +1,72 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestTools_DownloadStaticFile_ETag(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.csv")
+	if err := os.WriteFile(file, []byte("a,b\n1,2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testTool := Tools{ETags: true, CacheControl: CachePrivate, DownloadRoot: dir}
+	download := func(header, value string, opts DownloadOptions) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		r.Header.Set("Range", "bytes=0-1")
+		if header == "" {
+			r.Header.Del("Range")
+		}
+		testTool.DownloadStaticFileWithOptions(w, r, filepath.Join(dir, "report.csv"), opts)
+		return w
+	}
+
+	w := download("", "", DownloadOptions{})
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"492d5ea496056f1a6a6592241032fab7"` {
+		t.Fatalf("expected a content ETag, got %d %s", w.Code, etag)
+	}
+	if w.Header().Get("Cache-Control") != CachePrivate {
+		t.Errorf("wrong Cache-Control %s", w.Header().Get("Cache-Control"))
+	}
+
+	// a re-synced file with a new modification time keeps its ETag
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if w = download("If-None-Match", etag, DownloadOptions{CacheControl: CacheImmutable}); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching If-None-Match, got %d", w.Code)
+	}
+	if w.Header().Get("Cache-Control") != CacheImmutable {
+		t.Errorf("expected Cache-Control to be overridden, got %s", w.Header().Get("Cache-Control"))
+	}
+	if w = download("If-Range", etag, DownloadOptions{}); w.Code != http.StatusPartialContent {
+		t.Errorf("expected 206 for a matching If-Range, got %d", w.Code)
+	}
+	if w = download("If-Range", `"stale"`, DownloadOptions{}); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale If-Range, got %d", w.Code)
+	}
+}
+
+func TestTools_DownloadFS_ETagWithoutSeek(t *testing.T) {
+	fsys := noSeekFS{fstest.MapFS{"report.csv": {Data: []byte("a,b\n1,2\n")}}}
+	testTool := Tools{ETags: true}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `W/"other", "492d5ea496056f1a6a6592241032fab7"`)
+	testTool.DownloadFS(w, r, fsys, "report.csv", DownloadOptions{})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/ApmGor/toolkit/v2
 
-go 1.23
+go 1.24
@@ -0,0 +1,257 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxImagePixels is the largest source image, in pixels, ServeImageVariant agrees to decode
+const maxImagePixels = 50_000_000
+
+// Ways an image variant is fitted into the requested size
+const (
+	// FitContain scales the image to fit inside the size, keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to cover the size, keeping its aspect ratio, and crops what overflows
+	FitCover = "cover"
+	// FitFill stretches the image to the size
+	FitFill = "fill"
+)
+
+// ImageSize is an allowed size for image variants. A zero Width or Height is derived from the
+// aspect ratio of the source image.
+type ImageSize struct {
+	Width  int
+	Height int
+}
+
+// imageVariant holds the parameters of a requested image variant
+type imageVariant struct {
+	width, height int
+	fit           string
+	format        string
+	quality       int
+}
+
+// parseImageVariant reads the variant parameters w, h, fit, fmt and q from the query of r. The format
+// defaults to that of the source image, whose extension is ext.
+func (tools *Tools) parseImageVariant(r *http.Request, ext string) (imageVariant, error) {
+	q := r.URL.Query()
+	v := imageVariant{fit: FitContain, format: "png", quality: 85}
+	if ext == ".jpg" || ext == ".jpeg" {
+		v.format = "jpeg"
+	}
+	var err error
+	if s := q.Get("w"); s != "" {
+		if v.width, err = strconv.Atoi(s); err != nil || v.width < 0 {
+			return v, fmt.Errorf("invalid width %q", s)
+		}
+	}
+	if s := q.Get("h"); s != "" {
+		if v.height, err = strconv.Atoi(s); err != nil || v.height < 0 {
+			return v, fmt.Errorf("invalid height %q", s)
+		}
+	}
+	if s := q.Get("fit"); s != "" {
+		if s != FitContain && s != FitCover && s != FitFill {
+			return v, fmt.Errorf("invalid fit %q", s)
+		}
+		v.fit = s
+	}
+	if s := q.Get("fmt"); s != "" {
+		switch s {
+		case "jpeg", "jpg":
+			v.format = "jpeg"
+		case "png":
+			v.format = "png"
+		default:
+			return v, fmt.Errorf("invalid format %q", s)
+		}
+	}
+	if s := q.Get("q"); s != "" {
+		if v.quality, err = strconv.Atoi(s); err != nil || v.quality < 1 || v.quality > 100 {
+			return v, fmt.Errorf("invalid quality %q", s)
+		}
+	}
+	if v.width == 0 && v.height == 0 {
+		return v, nil
+	}
+	for _, size := range tools.ImageSizes {
+		if size.Width == v.width && size.Height == v.height {
+			return v, nil
+		}
+	}
+	return v, fmt.Errorf("image size %dx%d is not allowed", v.width, v.height)
+}
+
+// serveImageVariant sends the image name from fsys resized according to the query of r. Variants are
+// cached in Tools.ImageCacheDir, keyed by the content hash of the source and the variant parameters.
+func (tools *Tools) serveImageVariant(w http.ResponseWriter, r *http.Request, fsys fs.FS, identity any, name string) {
+	clean, ok := downloadPath(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ext := strings.ToLower(path.Ext(clean))
+	v, err := tools.parseImageVariant(r, ext)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v.width == 0 && v.height == 0 && r.URL.Query().Get("fmt") == "" {
+		tools.serveFile(w, r, fsys, identity, clean, DownloadOptions{Inline: true})
+		return
+	}
+	if !tools.authorize(w, r, clean) {
+		return
+	}
+	info, err := fs.Stat(fsys, clean)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	sourceETag, err := contentETag(fsys, identity, clean, info, nil)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%d|%s|%s|%d", sourceETag, v.width, v.height, v.fit, v.format, v.quality))
+	key := hex.EncodeToString(sum[:])
+	cacheDir := tools.ImageCacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "toolkit-image-variants")
+	}
+	cached := filepath.Join(cacheDir, key+"."+v.format)
+
+	data, err := os.ReadFile(cached)
+	if err != nil {
+		if data, err = renderImageVariant(fsys, clean, v); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err = tools.CreateDirIfNotExist(cacheDir); err == nil {
+			_ = writeFileAtomic(cached, data)
+		}
+	}
+
+	variantName := strings.TrimSuffix(path.Base(clean), path.Ext(clean)) + "." + v.format
+	w.Header().Set("Content-Type", "image/"+v.format)
+	w.Header().Set("Content-Disposition", contentDisposition(true, variantName))
+	w.Header().Set("ETag", formatETag(key))
+	if tools.CacheControl != "" {
+		w.Header().Set("Cache-Control", tools.CacheControl)
+	}
+	http.ServeContent(w, r, variantName, info.ModTime(), bytes.NewReader(data))
+}
+
+// renderImageVariant decodes the image name from fsys, resizes it and encodes it in the variant format
+func renderImageVariant(fsys fs.FS, name string, v imageVariant) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %w", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %w", err)
+	}
+
+	var out bytes.Buffer
+	img := fitImage(src, v.width, v.height, v.fit, v.format == "jpeg")
+	if v.format == "jpeg" {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: v.quality})
+	} else {
+		err = png.Encode(&out, img)
+	}
+	return out.Bytes(), err
+}
+
+// fitImage resizes src to width x height according to fit. A zero width or height is derived from
+// the aspect ratio of src. An opaque result is composited over white, for formats without alpha.
+func fitImage(src image.Image, width, height int, fit string, opaque bool) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	switch {
+	case width == 0 && height == 0:
+		width, height = sw, sh
+	case width == 0:
+		width = max(1, sw*height/sh)
+		fit = FitFill
+	case height == 0:
+		height = max(1, sh*width/sw)
+		fit = FitFill
+	}
+
+	crop := b
+	dw, dh := width, height
+	switch fit {
+	case FitContain:
+		if sw*height > sh*width {
+			dh = max(1, sh*width/sw)
+		} else {
+			dw = max(1, sw*height/sh)
+		}
+	case FitCover:
+		if sw*height > sh*width {
+			cw := max(1, sh*width/height)
+			crop = image.Rect(b.Min.X+(sw-cw)/2, b.Min.Y, b.Min.X+(sw-cw)/2+cw, b.Max.Y)
+		} else {
+			ch := max(1, sw*height/width)
+			crop = image.Rect(b.Min.X, b.Min.Y+(sh-ch)/2, b.Max.X, b.Min.Y+(sh-ch)/2+ch)
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	if opaque {
+		draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.Draw(rgba, rgba.Bounds(), src, crop.Min, draw.Over)
+	return resizeBox(rgba, dw, dh)
+}
+
+// resizeBox scales src to width x height, averaging the source pixels covered by each destination
+// pixel when shrinking, and repeating them when enlarging
+func resizeBox(src *image.RGBA, width, height int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max((y+1)*sh/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max((x+1)*sw/width, x0+1)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+uint32(p[0]), g+uint32(p[1]), b+uint32(p[2]), a+uint32(p[3])
+					n++
+				}
+			}
+			o := dst.PixOffset(x, y)
+			dst.Pix[o], dst.Pix[o+1], dst.Pix[o+2], dst.Pix[o+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
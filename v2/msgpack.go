@@ -0,0 +1,271 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// MessagePackCodec is the Codec for application/msgpack. Values are mapped like encoding/json maps
+// them to JSON, with byte slices as bin; timestamps are decoded as time.Time, other extension types
+// as their raw data.
+type MessagePackCodec struct {
+	AllowUnknownFields bool
+}
+
+func (MessagePackCodec) Name() string        { return "MessagePack" }
+func (MessagePackCodec) ContentType() string { return "application/msgpack" }
+
+func (MessagePackCodec) Encode(w io.Writer, v interface{}) error {
+	e := msgpackEncoder{buf: new(bytes.Buffer)}
+	if err := marshalValue(e, reflect.ValueOf(v), 0); err != nil {
+		return err
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+func (c MessagePackCodec) Decode(r io.Reader, v interface{}) error {
+	value, err := decodeBody(r, decodeMessagePack)
+	if err != nil {
+		return err
+	}
+	return decodeInto(v, value, !c.AllowUnknownFields)
+}
+
+// msgpackTimestamp is the extension type of timestamps
+const msgpackTimestamp = -1
+
+type msgpackEncoder struct {
+	buf *bytes.Buffer
+}
+
+// sized writes the format byte chosen among small, medium and large by the size n, followed by n
+// in one, two or four bytes
+func (e msgpackEncoder) sized(n int, small, medium, large byte) {
+	switch {
+	case n <= math.MaxUint8 && small != 0:
+		e.buf.Write([]byte{small, byte(n)})
+	case n <= math.MaxUint16:
+		e.buf.Write(binary.BigEndian.AppendUint16([]byte{medium}, uint16(n)))
+	default:
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{large}, uint32(n)))
+	}
+}
+
+func (e msgpackEncoder) encodeNil() { e.buf.WriteByte(0xc0) }
+
+func (e msgpackEncoder) encodeBool(b bool) {
+	if b {
+		e.buf.WriteByte(0xc3)
+	} else {
+		e.buf.WriteByte(0xc2)
+	}
+}
+
+func (e msgpackEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.buf.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		e.buf.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16:
+		e.buf.Write(binary.BigEndian.AppendUint16([]byte{0xd1}, uint16(i)))
+	case i >= math.MinInt32:
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{0xd2}, uint32(i)))
+	default:
+		e.buf.Write(binary.BigEndian.AppendUint64([]byte{0xd3}, uint64(i)))
+	}
+}
+
+func (e msgpackEncoder) encodeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		e.buf.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		e.buf.Write(binary.BigEndian.AppendUint16([]byte{0xcd}, uint16(u)))
+	case u <= math.MaxUint32:
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{0xce}, uint32(u)))
+	default:
+		e.buf.Write(binary.BigEndian.AppendUint64([]byte{0xcf}, u))
+	}
+}
+
+func (e msgpackEncoder) encodeFloat(f float64) {
+	if float64(float32(f)) == f {
+		e.buf.Write(binary.BigEndian.AppendUint32([]byte{0xca}, math.Float32bits(float32(f))))
+		return
+	}
+	e.buf.Write(binary.BigEndian.AppendUint64([]byte{0xcb}, math.Float64bits(f)))
+}
+
+func (e msgpackEncoder) encodeString(s string) {
+	if len(s) < 32 {
+		e.buf.WriteByte(0xa0 | byte(len(s)))
+	} else {
+		e.sized(len(s), 0xd9, 0xda, 0xdb)
+	}
+	e.buf.WriteString(s)
+}
+
+func (e msgpackEncoder) encodeBytes(b []byte) {
+	e.sized(len(b), 0xc4, 0xc5, 0xc6)
+	e.buf.Write(b)
+}
+
+func (e msgpackEncoder) encodeArrayHeader(n int) {
+	if n < 16 {
+		e.buf.WriteByte(0x90 | byte(n))
+		return
+	}
+	e.sized(n, 0, 0xdc, 0xdd)
+}
+
+func (e msgpackEncoder) encodeMapHeader(n int) {
+	if n < 16 {
+		e.buf.WriteByte(0x80 | byte(n))
+		return
+	}
+	e.sized(n, 0, 0xde, 0xdf)
+}
+
+// decodeMessagePack reads one MessagePack object as a generic value
+func decodeMessagePack(d *byteDecoder, depth int) (interface{}, error) {
+	if depth > maxCodecDepth {
+		return nil, errors.New("MessagePack value is nested too deeply")
+	}
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		s, err := d.readBytes(uint64(b & 0x1f))
+		return string(s), err
+	case b&0xf0 == 0x90:
+		return decodeMessagePackArray(d, uint64(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return decodeMessagePackMap(d, uint64(b&0x0f), depth)
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readUint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackExt(d, n)
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (b - 0xcc))
+		return uintValue(u), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		u, err := d.readUint(size)
+		// sign extend from size bytes
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeMessagePackExt(d, 1<<(b-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.readBytes(n)
+		return string(s), err
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackArray(d, n, depth)
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMessagePackMap(d, n, depth)
+	}
+	return nil, fmt.Errorf("malformed MessagePack: invalid format 0x%02x", b)
+}
+
+func decodeMessagePackArray(d *byteDecoder, n uint64, depth int) (interface{}, error) {
+	items := make([]interface{}, 0, capacity(n))
+	for i := uint64(0); i < n; i++ {
+		item, err := decodeMessagePack(d, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func decodeMessagePackMap(d *byteDecoder, n uint64, depth int) (interface{}, error) {
+	entries := make([]mapEntry, 0, capacity(n))
+	for i := uint64(0); i < n; i++ {
+		key, err := decodeMessagePack(d, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeMessagePack(d, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntry{key: key, value: value})
+	}
+	return entries, nil
+}
+
+// decodeMessagePackExt reads the type and the n bytes of data of an extension
+func decodeMessagePackExt(d *byteDecoder, n uint64) (interface{}, error) {
+	extType, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.readBytes(n)
+	if err != nil || int8(extType) != msgpackTimestamp {
+		return data, err
+	}
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&0x3ffffffff), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))).UTC(), nil
+	}
+	return nil, errors.New("malformed MessagePack: invalid timestamp")
+}
@@ -0,0 +1,117 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var messagePackEncodeTests = []struct {
+	value    interface{}
+	expected string
+}{
+	{value: 0, expected: "00"},
+	{value: 127, expected: "7f"},
+	{value: 128, expected: "cc80"},
+	{value: 65535, expected: "cdffff"},
+	{value: 65536, expected: "ce00010000"},
+	{value: uint64(math.MaxUint64), expected: "cfffffffffffffffff"},
+	{value: -1, expected: "ff"},
+	{value: -32, expected: "e0"},
+	{value: -33, expected: "d0df"},
+	{value: -129, expected: "d1ff7f"},
+	{value: -32769, expected: "d2ffff7fff"},
+	{value: int64(math.MinInt64), expected: "d38000000000000000"},
+	{value: 1.5, expected: "ca3fc00000"},
+	{value: 1.1, expected: "cb3ff199999999999a"},
+	{value: nil, expected: "c0"},
+	{value: false, expected: "c2"},
+	{value: true, expected: "c3"},
+	{value: "a", expected: "a161"},
+	{value: "abcdefghijklmnopqrstuvwxyz012345", expected: "d920" + hex.EncodeToString([]byte("abcdefghijklmnopqrstuvwxyz012345"))},
+	{value: []byte{1, 2}, expected: "c4020102"},
+	{value: []int{1, 2, 3}, expected: "93010203"},
+	{value: make([]bool, 16), expected: "dc0010" + "c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2c2"},
+	{value: map[string]interface{}{"compact": true, "schema": 0}, expected: "82a7636f6d70616374c3a6736368656d6100"},
+}
+
+func TestMessagePackCodec_Encode(t *testing.T) {
+	for _, e := range messagePackEncodeTests {
+		var out bytes.Buffer
+		if err := (MessagePackCodec{}).Encode(&out, e.value); err != nil {
+			t.Errorf("%v: %s", e.value, err)
+			continue
+		}
+		if got := hex.EncodeToString(out.Bytes()); got != e.expected {
+			t.Errorf("%v: expected %s, got %s", e.value, e.expected, got)
+		}
+	}
+}
+
+var messagePackDecodeTests = []struct {
+	data     string
+	expected interface{}
+}{
+	{data: "d0df", expected: int64(-33)},
+	{data: "d1ff7f", expected: int64(-129)},
+	{data: "cfffffffffffffffff", expected: uint64(math.MaxUint64)},
+	{data: "ca3fc00000", expected: 1.5},
+	{data: "da0003616263", expected: "abc"},
+	{data: "c50002abcd", expected: []byte{0xab, 0xcd}},
+	{data: "de0001a161c3", expected: map[string]interface{}{"a": true}},
+	{data: "d40105", expected: []byte{5}},
+	{data: "d6ff5a497a00", expected: time.Unix(1514764800, 0).UTC()},
+}
+
+func TestMessagePackCodec_Decode(t *testing.T) {
+	for _, e := range messagePackDecodeTests {
+		data, _ := hex.DecodeString(e.data)
+		var got interface{}
+		if err := (MessagePackCodec{}).Decode(bytes.NewReader(data), &got); err != nil {
+			t.Errorf("%s: %s", e.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %#v, got %#v", e.data, e.expected, got)
+		}
+	}
+}
+
+func TestMessagePackCodec_Timestamp(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	var stamped struct {
+		At time.Time `json:"at"`
+	}
+	// timestamp 64: nanoseconds in the upper 30 bits, seconds in the lower 34
+	v := uint64(ts.Nanosecond())<<34 | uint64(ts.Unix())
+	data, _ := hex.DecodeString("81a26174d7ff" + hex.EncodeToString(binary.BigEndian.AppendUint64(nil, v)))
+	if err := (MessagePackCodec{}).Decode(bytes.NewReader(data), &stamped); err != nil {
+		t.Fatal(err)
+	}
+	if !stamped.At.Equal(ts) {
+		t.Errorf("expected %s, got %s", ts, stamped.At)
+	}
+}
+
+func TestMessagePackCodec_DecodeMalformed(t *testing.T) {
+	malformed := []string{
+		"",           // empty
+		"c1",         // never used
+		"cd00",       // truncated integer
+		"a3616263ff", // trailing data
+		"92c3",       // truncated array
+		"dbffffffff", // forged length
+		"d6ff0000",   // truncated timestamp
+	}
+	for _, m := range malformed {
+		data, _ := hex.DecodeString(m)
+		var got interface{}
+		if err := (MessagePackCodec{}).Decode(bytes.NewReader(data), &got); err == nil {
+			t.Errorf("%s: expected an error, got %#v", m, got)
+		}
+	}
+}
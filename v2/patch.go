@@ -0,0 +1,577 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch documents read by ReadPatch
+const (
+	JSONPatchContentType  = "application/json-patch+json"
+	MergePatchContentType = "application/merge-patch+json"
+)
+
+// ErrPatchTestFailed is wrapped by the PatchError of a test operation whose value does not match
+var ErrPatchTestFailed = errors.New("value does not match")
+
+// PatchError is returned when an operation of a JSON Patch is invalid or cannot be applied. Path is the
+// JSON Pointer to the exact location of the failure, which may be a prefix of the operation's path.
+type PatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s) at %q: %v", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *PatchError) Unwrap() error {
+	return e.Err
+}
+
+// Patch is a patch document that can be applied to a JSON document
+type Patch interface {
+	// Apply returns doc with the patch applied. Object members come out sorted by key.
+	Apply(doc []byte) ([]byte, error)
+}
+
+// PatchOperation is an operation of an RFC 6902 JSON Patch. Value is nil when absent, which is not the
+// same as a JSON null.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// JSONPatch is an RFC 6902 JSON Patch. Its operations are applied in order, and none of them is applied
+// if any fails.
+type JSONPatch []PatchOperation
+
+// UnmarshalJSON decodes the operations of a JSON Patch, reporting move and copy operations that lack
+// the from member, which an empty From could not be told apart from
+func (p *JSONPatch) UnmarshalJSON(data []byte) error {
+	var ops []struct {
+		PatchOperation
+		From *string `json:"from"`
+	}
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return err
+	}
+	patch := make(JSONPatch, len(ops))
+	for i, op := range ops {
+		if op.From == nil && (op.Op == "move" || op.Op == "copy") {
+			return &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: errors.New("missing from")}
+		}
+		patch[i] = op.PatchOperation
+		if op.From != nil {
+			patch[i].From = *op.From
+		}
+	}
+	*p = patch
+	return nil
+}
+
+// MergePatch is an RFC 7396 JSON Merge Patch: its members replace those of the target, recursively for
+// objects, and its null members remove them.
+type MergePatch json.RawMessage
+
+// ReadPatch reads a patch document from a request: a JSONPatch if its Content-Type is
+// application/json-patch+json, or a MergePatch if it is application/merge-patch+json. Any other type
+// gets an UnsupportedMediaTypeError. The body is read like ReadJSON reads it, with the same size limit
+// and error messages, and the operations of a JSON Patch are checked before it is returned.
+func (tools *Tools) ReadPatch(w http.ResponseWriter, r *http.Request) (Patch, error) {
+	header := r.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(header)
+	switch mediaType {
+	case JSONPatchContentType:
+		var patch JSONPatch
+		if err := tools.readBody(w, r, JSONCodec{AllowUnknownFields: true}, &patch); err != nil {
+			return nil, err
+		}
+		if err := patch.validate(); err != nil {
+			return nil, err
+		}
+		return patch, nil
+	case MergePatchContentType:
+		var patch json.RawMessage
+		if err := tools.readBody(w, r, JSONCodec{}, &patch); err != nil {
+			return nil, err
+		}
+		return MergePatch(patch), nil
+	default:
+		return nil, &UnsupportedMediaTypeError{ContentType: header, Supported: []string{JSONPatchContentType, MergePatchContentType}}
+	}
+}
+
+// ApplyPatch applies patch to v, which must be a non-nil pointer. v is encoded to JSON and patched, and
+// the patched document is decoded into a copy of *v, which replaces *v only if every step succeeded.
+// Fields that JSON does not carry, unexported or tagged json:"-", keep their values; members that the
+// patch removed or set to null are cleared, and members that v's type does not have are an error.
+// Slices, maps and interfaces are decoded afresh, so the state hidden inside their elements is not kept.
+func ApplyPatch(patch Patch, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("patch target must be a non-nil pointer")
+	}
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if doc, err = patch.Apply(doc); err != nil {
+		return err
+	}
+	patchedDoc, err := decodeDocument(doc)
+	if err != nil {
+		return err
+	}
+	patched := reflect.New(rv.Elem().Type())
+	patched.Elem().Set(rv.Elem())
+	clearPatchedMembers(patched.Elem(), patchedDoc)
+	if err := decodeError((JSONCodec{}).Decode(bytes.NewReader(doc), patched.Interface()), "JSON", len(doc)); err != nil {
+		return err
+	}
+	rv.Elem().Set(patched.Elem())
+	return nil
+}
+
+// clearPatchedMembers prepares v, a copy of the value being patched, for decoding the patched document
+// doc into it: struct fields whose member doc lacks or holds null are zeroed, as decoding would leave
+// them alone, and pointers, slices, maps and interfaces are replaced so that decoding does not write
+// through them into the original value. Fields that JSON does not carry are left alone.
+func clearPatchedMembers(v reflect.Value, doc interface{}) {
+	if !v.CanSet() {
+		return
+	}
+	t := v.Type()
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		// decoding replaces these as a whole
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		members, _ := doc.(map[string]interface{})
+		for _, f := range codecFields(t) {
+			fv, ok := fieldByIndex(v, f.index, false)
+			if !ok || !fv.CanSet() {
+				continue
+			}
+			if member, ok := members[f.name]; ok && member != nil {
+				clearPatchedMembers(fv, member)
+			} else {
+				fv.SetZero()
+			}
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			elem := reflect.New(t.Elem())
+			elem.Elem().Set(v.Elem())
+			clearPatchedMembers(elem.Elem(), doc)
+			v.Set(elem)
+		}
+	case reflect.Slice, reflect.Map, reflect.Interface:
+		v.SetZero()
+	}
+}
+
+// Apply implements Patch
+func (p JSONPatch) Apply(doc []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	root, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range p {
+		if root, err = op.apply(root); err != nil {
+			var patchError *PatchError
+			if errors.As(err, &patchError) {
+				patchError.Index, patchError.Op = i, op.Op
+			}
+			return nil, err
+		}
+	}
+	return json.Marshal(root)
+}
+
+// Apply implements Patch
+func (p MergePatch) Apply(doc []byte) ([]byte, error) {
+	root, err := decodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodeDocument(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return json.Marshal(mergePatch(root, patch))
+}
+
+// mergePatch applies a merge patch to target as described by RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for key, value := range members {
+		if value == nil {
+			delete(object, key)
+		} else {
+			object[key] = mergePatch(object[key], value)
+		}
+	}
+	return object
+}
+
+// decodeDocument decodes a JSON document, keeping numbers as they were written
+func decodeDocument(doc []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, ErrTrailingData
+	}
+	return v, nil
+}
+
+// validate checks that every operation of p is known, has valid pointers and has the members it needs
+func (p JSONPatch) validate() error {
+	for i, op := range p {
+		var err error
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				err = errors.New("missing value")
+			}
+		case "remove":
+		case "move", "copy":
+			if _, err = parsePointer(op.From); err != nil {
+				return &PatchError{Index: i, Op: op.Op, Path: op.From, Err: err}
+			}
+		default:
+			err = errors.New("unknown operation")
+		}
+		if err == nil {
+			_, err = parsePointer(op.Path)
+		}
+		if err != nil {
+			return &PatchError{Index: i, Op: op.Op, Path: op.Path, Err: err}
+		}
+	}
+	return nil
+}
+
+// apply applies op to root, returning the new root
+func (op PatchOperation) apply(root interface{}) (interface{}, error) {
+	path, _ := parsePointer(op.Path)
+	var value interface{}
+	if op.Value != nil {
+		var err error
+		if value, err = decodeDocument(op.Value); err != nil {
+			return nil, &PatchError{Path: op.Path, Err: err}
+		}
+	}
+	switch op.Op {
+	case "add":
+		return addValue(root, path, value)
+	case "remove":
+		root, _, err := removeValue(root, path)
+		return root, err
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		return modify(root, path, func(parent interface{}, at pointer) (interface{}, error) {
+			switch parent := parent.(type) {
+			case map[string]interface{}:
+				if _, ok := parent[at.last()]; !ok {
+					return nil, &PatchError{Path: at.String(), Err: errors.New("member does not exist")}
+				}
+				parent[at.last()] = value
+			case []interface{}:
+				i, err := arrayIndex(at, len(parent)-1)
+				if err != nil {
+					return nil, err
+				}
+				parent[i] = value
+			}
+			return parent, nil
+		})
+	case "move":
+		from, _ := parsePointer(op.From)
+		if from.String() == path.String() {
+			return root, nil
+		}
+		if from.isPrefixOf(path) {
+			return nil, &PatchError{Path: op.Path, Err: errors.New("cannot move a value into one of its children")}
+		}
+		root, moved, err := removeValue(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, moved)
+	case "copy":
+		from, _ := parsePointer(op.From)
+		copied, err := getValue(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, deepCopy(copied))
+	default: // test
+		current, err := getValue(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, &PatchError{Path: op.Path, Err: ErrPatchTestFailed}
+		}
+		return root, nil
+	}
+}
+
+// addValue adds value at path, inserting it into arrays and replacing existing object members
+func addValue(root interface{}, path pointer, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modify(root, path, func(parent interface{}, at pointer) (interface{}, error) {
+		switch parent := parent.(type) {
+		case map[string]interface{}:
+			parent[at.last()] = value
+			return parent, nil
+		default:
+			array := parent.([]interface{})
+			i := len(array)
+			if at.last() != "-" {
+				var err error
+				if i, err = arrayIndex(at, len(array)); err != nil {
+					return nil, err
+				}
+			}
+			return append(array[:i], append([]interface{}{value}, array[i:]...)...), nil
+		}
+	})
+}
+
+// removeValue removes the value at path, and returns it along with the new root
+func removeValue(root interface{}, path pointer) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, &PatchError{Err: errors.New("cannot remove the whole document")}
+	}
+	var removed interface{}
+	root, err := modify(root, path, func(parent interface{}, at pointer) (interface{}, error) {
+		switch parent := parent.(type) {
+		case map[string]interface{}:
+			value, ok := parent[at.last()]
+			if !ok {
+				return nil, &PatchError{Path: at.String(), Err: errors.New("member does not exist")}
+			}
+			removed = value
+			delete(parent, at.last())
+			return parent, nil
+		default:
+			array := parent.([]interface{})
+			i, err := arrayIndex(at, len(array)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = array[i]
+			return append(array[:i:i], array[i+1:]...), nil
+		}
+	})
+	return root, removed, err
+}
+
+// getValue returns the value at path
+func getValue(root interface{}, path pointer) (interface{}, error) {
+	node := root
+	for i := range path {
+		at := path[:i+1]
+		switch parent := node.(type) {
+		case map[string]interface{}:
+			value, ok := parent[at.last()]
+			if !ok {
+				return nil, &PatchError{Path: at.String(), Err: errors.New("member does not exist")}
+			}
+			node = value
+		case []interface{}:
+			index, err := arrayIndex(at, len(parent)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = parent[index]
+		default:
+			return nil, &PatchError{Path: path[:i].String(), Err: errors.New("not an object or array")}
+		}
+	}
+	return node, nil
+}
+
+// modify calls change with the object or array holding the last token of path, and with path, then
+// stores the container it returns back into root, which it returns
+func modify(root interface{}, path pointer, change func(parent interface{}, at pointer) (interface{}, error)) (interface{}, error) {
+	parentPath := path[:len(path)-1]
+	parent, err := getValue(root, parentPath)
+	if err != nil {
+		return nil, err
+	}
+	switch parent.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return nil, &PatchError{Path: parentPath.String(), Err: errors.New("not an object or array")}
+	}
+	changed, err := change(parent, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(parentPath) == 0 {
+		return changed, nil
+	}
+	// arrays may have been reallocated, so the container is stored again in its own parent
+	return modify(root, parentPath, func(grandparent interface{}, at pointer) (interface{}, error) {
+		switch grandparent := grandparent.(type) {
+		case map[string]interface{}:
+			grandparent[at.last()] = changed
+		case []interface{}:
+			i, _ := arrayIndex(at, len(grandparent)-1)
+			grandparent[i] = changed
+		}
+		return grandparent, nil
+	})
+}
+
+// arrayIndex parses the last token of at as an index into an array, which must not exceed maxIndex
+func arrayIndex(at pointer, maxIndex int) (int, error) {
+	token := at.last()
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || strings.TrimLeft(token, "0123456789") != "" || (len(token) > 1 && token[0] == '0') {
+		return 0, &PatchError{Path: at.String(), Err: errors.New("invalid array index")}
+	}
+	if i > maxIndex {
+		return 0, &PatchError{Path: at.String(), Err: errors.New("index out of range")}
+	}
+	return i, nil
+}
+
+// jsonEqual compares two decoded JSON values, numbers by value
+func jsonEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okX := new(big.Rat).SetString(a.String())
+		y, okY := new(big.Rat).SetString(b.String())
+		return okX && okY && x.Cmp(y) == 0
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// deepCopy copies a decoded JSON value, so that copies do not share objects or arrays
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			c[key] = deepCopy(value)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, value := range v {
+			c[i] = deepCopy(value)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// pointer is a parsed RFC 6901 JSON Pointer: the unescaped reference tokens
+type pointer []string
+
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+	// pointerEscapes removes the valid escapes from a token, leaving any invalid tilde behind
+	pointerEscapes = strings.NewReplacer("~0", "", "~1", "")
+)
+
+// parsePointer parses a JSON Pointer, which is empty or starts with a slash
+func parsePointer(s string) (pointer, error) {
+	if s == "" {
+		return pointer{}, nil
+	}
+	if s[0] != '/' {
+		return nil, errors.New("invalid JSON pointer")
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, token := range tokens {
+		if strings.Contains(pointerEscapes.Replace(token), "~") {
+			return nil, errors.New("invalid JSON pointer")
+		}
+		tokens[i] = pointerUnescaper.Replace(token)
+	}
+	return tokens, nil
+}
+
+func (p pointer) String() string {
+	var b strings.Builder
+	for _, token := range p {
+		b.WriteString("/" + pointerEscaper.Replace(token))
+	}
+	return b.String()
+}
+
+func (p pointer) last() string {
+	return p[len(p)-1]
+}
+
+// isPrefixOf reports whether p refers to a proper ancestor of other
+func (p pointer) isPrefixOf(other pointer) bool {
+	return len(p) < len(other) && slices.Equal(p, other[:len(p)])
+}
@@ -0,0 +1,246 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// compactJSON re-encodes a JSON document so that documents can be compared regardless of spacing and
+// member order
+func compactJSON(t *testing.T, doc string) string {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", doc, err)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
+
+var jsonPatchTests = []struct {
+	name     string
+	doc      string
+	patch    string
+	expected string
+	// errorPath is the path reported by the PatchError, when one is expected
+	errorPath string
+	errorOp   int
+}{
+	{name: "add member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, expected: `{"baz":"qux","foo":"bar"}`},
+	{name: "add array element", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, expected: `{"foo":["bar","qux","baz"]}`},
+	{name: "append", doc: `{"foo":[1]}`, patch: `[{"op":"add","path":"/foo/-","value":[2]}]`, expected: `{"foo":[1,[2]]}`},
+	{name: "add null", doc: `{}`, patch: `[{"op":"add","path":"/a","value":null}]`, expected: `{"a":null}`},
+	{name: "replace document", doc: `{"a":1}`, patch: `[{"op":"replace","path":"","value":[1]}]`, expected: `[1]`},
+	{name: "remove member", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, expected: `{"foo":"bar"}`},
+	{name: "remove element", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, expected: `{"foo":["bar","baz"]}`},
+	{name: "replace", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, expected: `{"baz":"boo","foo":"bar"}`},
+	{name: "move member", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+		expected: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+	{name: "move element", doc: `{"foo":["all","grass","cows","eat"]}`, patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, expected: `{"foo":["all","cows","eat","grass"]}`},
+	{name: "copy", doc: `{"a":{"b":[1]}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"add","path":"/c/b/-","value":2}]`, expected: `{"a":{"b":[1]},"c":{"b":[1,2]}}`},
+	{name: "test", doc: `{"baz":"qux","foo":["a",2,"c"],"n":1}`, patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2},{"op":"test","path":"/n","value":1.0e0}]`,
+		expected: `{"baz":"qux","foo":["a",2,"c"],"n":1}`},
+	{name: "escaped pointer", doc: `{"/":9,"~1":10}`, patch: `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, expected: `{"~1":10}`},
+	{name: "nested", doc: `{"a":[{"b":1},{"b":2}]}`, patch: `[{"op":"replace","path":"/a/1/b","value":3},{"op":"add","path":"/a/0/c","value":4}]`, expected: `{"a":[{"b":1,"c":4},{"b":3}]}`},
+
+	{name: "failed test", doc: `{"baz":"qux"}`, patch: `[{"op":"add","path":"/a","value":1},{"op":"test","path":"/baz","value":"bar"}]`, errorPath: "/baz", errorOp: 1},
+	{name: "missing member", doc: `{"a":{}}`, patch: `[{"op":"replace","path":"/a/b/c","value":1}]`, errorPath: "/a/b"},
+	{name: "through a scalar", doc: `{"a":{"b":1}}`, patch: `[{"op":"add","path":"/a/b/c","value":1}]`, errorPath: "/a/b"},
+	{name: "index out of range", doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/2","value":1}]`, errorPath: "/a/2"},
+	{name: "leading zero", doc: `{"a":[1,2]}`, patch: `[{"op":"remove","path":"/a/01"}]`, errorPath: "/a/01"},
+	{name: "remove missing", doc: `{"a":[1]}`, patch: `[{"op":"remove","path":"/a/1"}]`, errorPath: "/a/1"},
+	{name: "move into itself", doc: `{"a":{"b":{}}}`, patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`, errorPath: "/a/b/c"},
+	{name: "missing value", doc: `{}`, patch: `[{"op":"add","path":"/a"}]`, errorPath: "/a"},
+	{name: "unknown operation", doc: `{}`, patch: `[{"op":"append","path":"/a","value":1}]`, errorPath: "/a"},
+	{name: "invalid pointer", doc: `{}`, patch: `[{"op":"remove","path":"/a"},{"op":"remove","path":"a"}]`, errorPath: "a", errorOp: 1},
+}
+
+func TestJSONPatch_Apply(t *testing.T) {
+	for _, e := range jsonPatchTests {
+		var patch JSONPatch
+		if err := json.Unmarshal([]byte(e.patch), &patch); err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		out, err := patch.Apply([]byte(e.doc))
+		if e.errorPath == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			} else if string(out) != compactJSON(t, e.expected) {
+				t.Errorf("%s: expected %s, got %s", e.name, compactJSON(t, e.expected), out)
+			}
+			continue
+		}
+		var patchError *PatchError
+		if !errors.As(err, &patchError) || patchError.Path != e.errorPath || patchError.Index != e.errorOp {
+			t.Errorf("%s: expected an error at operation %d, %q, got %v", e.name, e.errorOp, e.errorPath, err)
+		}
+	}
+}
+
+func TestJSONPatch_TestFailed(t *testing.T) {
+	patch := JSONPatch{{Op: "test", Path: "/version", Value: json.RawMessage(`2`)}}
+	_, err := patch.Apply([]byte(`{"version":1}`))
+	if !errors.Is(err, ErrPatchTestFailed) || err.Error() != `patch operation 0 (test) at "/version": value does not match` {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	var testTools Tools
+	_ = testTools.ErrorJSON(rr, err)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rr.Code)
+	}
+}
+
+func TestMergePatch_Apply(t *testing.T) {
+	// the examples of RFC 7396, appendix A
+	var tests = []struct{ doc, patch, expected string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"n":12345678901234567890}`, `{"m":1}`, `{"m":1,"n":12345678901234567890}`},
+	}
+	for _, e := range tests {
+		out, err := MergePatch(e.patch).Apply([]byte(e.doc))
+		if err != nil || string(out) != e.expected {
+			t.Errorf("%s merged with %s: expected %s, got %s (%v)", e.doc, e.patch, e.expected, out, err)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	type item struct {
+		Name  string   `json:"name"`
+		Price *float64 `json:"price,omitempty"`
+		Tags  []string `json:"tags"`
+	}
+	price := 10.0
+	target := item{Name: "lamp", Price: &price, Tags: []string{"home"}}
+
+	if err := ApplyPatch(MergePatch(`{"price":null,"tags":["home","light"]}`), &target); err != nil {
+		t.Fatal(err)
+	}
+	if target.Price != nil || strings.Join(target.Tags, ",") != "home,light" || target.Name != "lamp" {
+		t.Errorf("unexpected merge result %+v", target)
+	}
+
+	patch := JSONPatch{{Op: "replace", Path: "/name", Value: json.RawMessage(`"desk lamp"`)}, {Op: "remove", Path: "/tags/0"}}
+	if err := ApplyPatch(patch, &target); err != nil {
+		t.Fatal(err)
+	}
+	if target.Name != "desk lamp" || strings.Join(target.Tags, ",") != "light" {
+		t.Errorf("unexpected patch result %+v", target)
+	}
+
+	// a failing patch leaves the target alone
+	before := target.Name
+	if err := ApplyPatch(JSONPatch{{Op: "replace", Path: "/name", Value: json.RawMessage(`1`)}}, &target); err == nil || target.Name != before {
+		t.Errorf("expected a type error and an unchanged target, got %v, %+v", err, target)
+	}
+	if err := ApplyPatch(MergePatch(`{"colour":"red"}`), &target); err == nil || err.Error() != `body contains unknown key "colour"` {
+		t.Errorf("expected an unknown key error, got %v", err)
+	}
+	if err := ApplyPatch(MergePatch(`{}`), target); err == nil {
+		t.Error("expected an error for a non-pointer target")
+	}
+}
+
+func TestApplyPatch_HiddenFields(t *testing.T) {
+	type profile struct {
+		Bio     string `json:"bio"`
+		version int
+	}
+	type user struct {
+		Name         string            `json:"name"`
+		Email        string            `json:"email,omitempty"`
+		PasswordHash string            `json:"-"`
+		Profile      profile           `json:"profile"`
+		Avatar       *profile          `json:"avatar,omitempty"`
+		Labels       map[string]string `json:"labels"`
+		loadedAt     int
+	}
+	u := user{Name: "a", Email: "a@example.com", PasswordHash: "secret", Profile: profile{Bio: "hi", version: 3},
+		Avatar: &profile{Bio: "png", version: 1}, Labels: map[string]string{"team": "x"}, loadedAt: 42}
+
+	if err := ApplyPatch(MergePatch(`{"name":"b","email":null,"profile":{"bio":"hello"}}`), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "b" || u.Email != "" || u.PasswordHash != "secret" || u.loadedAt != 42 {
+		t.Errorf("unexpected fields after the patch: %+v", u)
+	}
+	if u.Profile.Bio != "hello" || u.Profile.version != 3 || u.Avatar == nil || u.Avatar.version != 1 {
+		t.Errorf("unexpected nested fields after the patch: %+v, %+v", u.Profile, u.Avatar)
+	}
+
+	patch := JSONPatch{{Op: "remove", Path: "/avatar"}, {Op: "replace", Path: "/name", Value: json.RawMessage(`null`)}}
+	if err := ApplyPatch(patch, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Avatar != nil || u.Name != "" || u.PasswordHash != "secret" {
+		t.Errorf("expected the removed members to be cleared, got %+v", u)
+	}
+
+	// a patch that fails to decode does not write through to the original value
+	avatar := &profile{Bio: "png"}
+	u.Avatar = avatar
+	err := ApplyPatch(MergePatch(`{"avatar":{"bio":"jpg"},"labels":{"team":"y","size":1}}`), &u)
+	if err == nil || avatar.Bio != "png" || u.Labels["team"] != "x" {
+		t.Errorf("expected an unchanged original after a failed patch, got %v, %+v, %v", err, avatar, u.Labels)
+	}
+}
+
+func TestTools_ReadPatch(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 256}
+	read := func(contentType, body string) (Patch, error) {
+		req := httptest.NewRequest(http.MethodPatch, "/items/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		return testTools.ReadPatch(httptest.NewRecorder(), req)
+	}
+
+	patch, err := read("application/json-patch+json", `[{"op":"add","path":"/a","value":null,"comment":"ignored"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ops, ok := patch.(JSONPatch); !ok || len(ops) != 1 || string(ops[0].Value) != "null" {
+		t.Errorf("unexpected JSON Patch %#v", patch)
+	}
+
+	patch, err = read("application/merge-patch+json; charset=utf-8", `{"a":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merge, ok := patch.(MergePatch); !ok || string(merge) != `{"a":null}` {
+		t.Errorf("unexpected merge patch %#v", patch)
+	}
+
+	var unsupported *UnsupportedMediaTypeError
+	if _, err := read("application/json", `{}`); !errors.As(err, &unsupported) || len(unsupported.Supported) != 2 {
+		t.Errorf("expected an UnsupportedMediaTypeError, got %v", err)
+	}
+	var patchError *PatchError
+	if _, err := read("application/json-patch+json", `[{"op":"add","path":"/b","value":1},{"op":"copy","path":"/a"}]`); !errors.As(err, &patchError) || patchError.Index != 1 || patchError.Path != "/a" {
+		t.Errorf("expected the missing from to be reported, got %v", err)
+	}
+	if _, err := read("application/json-patch+json", `{"op":"add"}`); err == nil || err.Error() != `body contains incorrect JSON type (at character 1)` {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if _, err := read("application/merge-patch+json", `{"a":"`+strings.Repeat("x", 300)+`"}`); err == nil || err.Error() != "body must not be larger than 256 bytes" {
+		t.Errorf("expected the size limit to apply, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTools_UploadFiles_Quarantine(t *testing.T) {
+	uploadDir := t.TempDir()
+	reject := errors.New("infected")
+	scan := func(ctx context.Context, path string, file *UploadedFile) error {
+		if file.OriginalFileName == "virus.txt" {
+			return reject
+		}
+		return nil
+	}
+	q, err := NewQuarantine(filepath.Join(t.TempDir(), "quarantine"), 2, scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := make(chan UploadJob, 2)
+	q.OnComplete = func(job UploadJob) {
+		completed <- job
+	}
+	testTools := Tools{Quarantine: q}
+
+	clean, err := testTools.UploadOneFile(newUploadRequest(t, "clean.txt", []byte("hello")), uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infected, err := testTools.UploadOneFile(newUploadRequest(t, "virus.txt", []byte("EICAR")), uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean.ID == "" || infected.ID == "" {
+		t.Fatal("expected quarantined files to have an ID")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-completed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for quarantine workers")
+		}
+	}
+
+	job, ok := q.Status(clean.ID)
+	if !ok || job.Status != UploadDone {
+		t.Errorf("expected clean file to be done, got %+v", job)
+	}
+	if _, err = os.Stat(filepath.Join(uploadDir, clean.NewFileName)); err != nil {
+		t.Errorf("expected clean file to be promoted: %s", err)
+	}
+
+	job, ok = q.Status(infected.ID)
+	if !ok || job.Status != UploadFailed || !errors.Is(job.Err, reject) {
+		t.Errorf("expected infected file to fail, got %+v", job)
+	}
+	if _, err = os.Stat(filepath.Join(uploadDir, infected.NewFileName)); !os.IsNotExist(err) {
+		t.Error("expected infected file not to be promoted")
+	}
+	if _, err = os.Stat(q.path(infected.ID)); !os.IsNotExist(err) {
+		t.Error("expected infected file to be removed from quarantine")
+	}
+
+	if err = q.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if _, err = testTools.UploadOneFile(newUploadRequest(t, "late.txt", []byte("late")), uploadDir); err == nil {
+		t.Error("expected error uploading to a closed quarantine")
+	}
+}
+
+func TestTools_UploadFiles_QuarantineFull(t *testing.T) {
+	uploadDir := t.TempDir()
+	dir := t.TempDir()
+	// a quarantine without workers, whose queue holds a single upload
+	q := &Quarantine{Dir: dir, jobs: make(map[string]*UploadJob), queue: make(chan quarantined, 1)}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	defer func() { _ = q.Close(context.Background()) }()
+	store := NewMemoryQuotaStore()
+	testTools := Tools{Quarantine: q, Quota: &Quota{Store: store, Limit: 100}}
+
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "first.txt", []byte("hello")), uploadDir); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := testTools.UploadOneFile(newUploadRequest(t, "second.txt", []byte("world")), uploadDir)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrQuarantineFull) {
+			t.Errorf("expected ErrQuarantineFull, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload blocked on a full quarantine")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || len(q.jobs) != 1 {
+		t.Errorf("expected only the first upload to be kept, got %d files and %d jobs", len(entries), len(q.jobs))
+	}
+	if usage, _ := store.Usage(SharedQuotaOwner); usage != 5 {
+		t.Errorf("expected the rejected upload to be released from the quota, usage is %d", usage)
+	}
+}
+
+func TestTools_UploadFiles_QuarantineNotStarted(t *testing.T) {
+	q := &Quarantine{Dir: t.TempDir()}
+	testTools := Tools{Quarantine: q}
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "data.txt", []byte("hello")), t.TempDir()); !errors.Is(err, ErrQuarantineNotStarted) {
+		t.Errorf("expected ErrQuarantineNotStarted, got %v", err)
+	}
+	if err := q.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,173 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTools_UploadFiles_Quota(t *testing.T) {
+	uploadDir := t.TempDir()
+	store := NewMemoryQuotaStore()
+	testTools := Tools{Quota: &Quota{
+		Store: store,
+		Limit: 100,
+		Owner: func(r *http.Request) (string, error) {
+			return r.Header.Get("X-User"), nil
+		},
+	}}
+	upload := func(size int) (*UploadedFile, error) {
+		request := newUploadRequest(t, "data.txt", bytes.Repeat([]byte("a"), size))
+		request.Header.Set("X-User", "alice")
+		return testTools.UploadOneFile(request, uploadDir)
+	}
+
+	first, err := upload(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := store.Usage("alice"); usage != 60 || first.Owner != "alice" {
+		t.Errorf("expected usage of 60 for alice, got %d (owner %q)", usage, first.Owner)
+	}
+
+	var quotaErr *QuotaExceededError
+	if _, err = upload(50); !errors.As(err, &quotaErr) {
+		t.Fatalf("expected QuotaExceededError, got %v", err)
+	}
+	entries, _ := os.ReadDir(uploadDir)
+	if len(entries) != 1 {
+		t.Errorf("expected the partial upload to be removed, found %d files", len(entries))
+	}
+	if usage, _ := store.Usage("alice"); usage != 60 {
+		t.Errorf("expected usage to stay at 60, got %d", usage)
+	}
+
+	if err = testTools.DeleteUploadedFile("alice", filepath.Join(uploadDir, first.NewFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := store.Usage("alice"); usage != 0 {
+		t.Errorf("expected usage to be released on delete, got %d", usage)
+	}
+	if _, err = upload(100); err != nil {
+		t.Errorf("expected upload to fit the quota after delete: %s", err)
+	}
+	if _, err = upload(1); !errors.As(err, &quotaErr) {
+		t.Errorf("expected upload to be refused when the quota is used up, got %v", err)
+	}
+}
+
+func TestTools_UploadFiles_QuotaDefaults(t *testing.T) {
+	uploadDir := t.TempDir()
+	testTools := Tools{Quota: &Quota{Limit: 100}}
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, "data.txt", []byte("a")), uploadDir); !errors.Is(err, ErrQuotaStoreNotSet) {
+		t.Errorf("expected ErrQuotaStoreNotSet, got %v", err)
+	}
+
+	// without Owner, every upload counts against the same quota
+	store := NewMemoryQuotaStore()
+	testTools.Quota.Store = store
+	uploaded, err := testTools.UploadOneFile(newUploadRequest(t, "data.txt", bytes.Repeat([]byte("a"), 60)), uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := store.Usage(SharedQuotaOwner); usage != 60 || uploaded.Owner != SharedQuotaOwner {
+		t.Errorf("expected the shared owner to use 60 bytes, got %d (owner %q)", usage, uploaded.Owner)
+	}
+	var quotaErr *QuotaExceededError
+	if _, err = testTools.UploadOneFile(newUploadRequest(t, "data.txt", bytes.Repeat([]byte("a"), 60)), uploadDir); !errors.As(err, &quotaErr) {
+		t.Errorf("expected QuotaExceededError, got %v", err)
+	}
+	if err = testTools.DeleteUploadedFile("", filepath.Join(uploadDir, uploaded.NewFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := store.Usage(SharedQuotaOwner); usage != 0 {
+		t.Errorf("expected usage to be released on delete, got %d", usage)
+	}
+}
+
+func TestTools_UploadFiles_QuotaOverwrite(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 600)
+	for _, rename := range []bool{false, true} {
+		uploadDir := t.TempDir()
+		store := NewMemoryQuotaStore()
+		testTools := Tools{Quota: &Quota{Store: store, Limit: 1000}, WriteMetadata: true, RenameStrategy: RenameContentHash}
+		var uploaded *UploadedFile
+		for i := 0; i < 3; i++ {
+			var err error
+			if uploaded, err = testTools.UploadOneFile(newUploadRequest(t, "data.txt", content), uploadDir, rename); err != nil {
+				t.Fatalf("rename %v, upload %d: %s", rename, i, err)
+			}
+		}
+		if usage, _ := store.Usage(SharedQuotaOwner); usage != 600 {
+			t.Errorf("rename %v: expected the overwritten file to be released, usage is %d", rename, usage)
+		}
+		if err := testTools.DeleteUploadedFile("", filepath.Join(uploadDir, uploaded.NewFileName)); err != nil {
+			t.Fatal(err)
+		}
+		if usage, _ := store.Usage(SharedQuotaOwner); usage != 0 {
+			t.Errorf("rename %v: expected usage to be released on delete, got %d", rename, usage)
+		}
+	}
+}
+
+func TestQuota_ConcurrentUploads(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	quota := &Quota{Store: store, Limit: 100 << 20}
+	if _, err := store.Add("alice", 90<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	// two uploads that pass the initial check reserve their bytes as they are read
+	first, err := quota.reader("alice", bytes.NewReader(make([]byte, 6<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := quota.reader("alice", bytes.NewReader(make([]byte, 6<<20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first upload has been read in full, but not committed yet
+	if _, err = io.Copy(io.Discard, first); err != nil {
+		t.Fatal(err)
+	}
+	var quotaErr *QuotaExceededError
+	if _, err = io.Copy(io.Discard, second); !errors.As(err, &quotaErr) {
+		t.Fatalf("expected the second upload to run out of quota, got %v", err)
+	}
+	if err = first.commit(first.read); err != nil {
+		t.Fatal(err)
+	}
+	second.cancel()
+	if usage, _ := store.Usage("alice"); usage != 96<<20 {
+		t.Errorf("expected usage of %d, got %d", 96<<20, usage)
+	}
+}
+
+func TestFileQuotaStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	store, err := NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Add("bob", 42); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := store.Add("bob", -50); usage != 0 {
+		t.Errorf("expected usage not to go below zero, got %d", usage)
+	}
+	if _, err = store.Add("bob", 7); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := reloaded.Usage("bob"); usage != 7 {
+		t.Errorf("expected persisted usage of 7, got %d", usage)
+	}
+}
@@ -0,0 +1,113 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const slugSuffixSource = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// RenameStrategy selects how UploadFiles names stored files when renaming is enabled
+type RenameStrategy int
+
+const (
+	// RenameRandom names files with a 25 character random string (the default)
+	RenameRandom RenameStrategy = iota
+	// RenameUUID names files with a random (version 4) UUID
+	RenameUUID
+	// RenameTimestamp names files with a UTC timestamp followed by a short random string
+	RenameTimestamp
+	// RenameContentHash names files with the hex encoded SHA-256 of their content
+	RenameContentHash
+	// RenameSlug names files with the slug of the original name and a short random suffix
+	RenameSlug
+)
+
+// RenameFunc is a custom naming hook for uploaded files. It receives the original file name
+// and the file content, and returns the new name, including any extension.
+type RenameFunc func(originalName string, content io.Reader) (string, error)
+
+// newFileName generates the name an uploaded file is stored under when renaming is enabled.
+// The content is rewound to the start before returning.
+func (tools *Tools) newFileName(originalName string, content io.ReadSeeker) (string, error) {
+	ext := safeExt(originalName)
+	var name string
+	var err error
+	switch {
+	case tools.RenameFunc != nil:
+		name, err = tools.RenameFunc(originalName, content)
+		if err == nil && (name == "" || name != filepath.Base(name) || name == "." || name == "..") {
+			err = fmt.Errorf("rename function returned an invalid file name %q", name)
+		}
+	case tools.RenameStrategy == RenameUUID:
+		name, err = newUUID()
+		name += ext
+	case tools.RenameStrategy == RenameTimestamp:
+		name = fmt.Sprintf("%s-%s%s", time.Now().UTC().Format("20060102T150405.000000000Z"),
+			randomStringFrom(slugSuffixSource, 6), ext)
+	case tools.RenameStrategy == RenameContentHash:
+		h := sha256.New()
+		if _, err = io.Copy(h, content); err == nil {
+			name = hex.EncodeToString(h.Sum(nil)) + ext
+		}
+	case tools.RenameStrategy == RenameSlug:
+		slug, slugErr := tools.Slugify(strings.TrimSuffix(filepath.Base(originalName), filepath.Ext(originalName)))
+		if slugErr != nil {
+			slug = "file"
+		}
+		name = fmt.Sprintf("%s-%s%s", slug, randomStringFrom(slugSuffixSource, 4), ext)
+	default:
+		name = fmt.Sprintf("%s%s", tools.RandomString(25), filepath.Ext(originalName))
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err = content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// safeExt returns the lower-cased extension of name, or an empty string if the extension
+// contains anything other than ASCII letters and digits
+func safeExt(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if len(ext) < 2 {
+		return ""
+	}
+	for _, c := range ext[1:] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// newUUID returns a random (version 4) UUID in its canonical textual form
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
+
+// randomStringFrom returns a random string of the given length made of characters from source
+func randomStringFrom(source string, length int) string {
+	s, r := make([]rune, length), []rune(source)
+	max := big.NewInt(int64(len(r)))
+	for i := range s {
+		n, _ := rand.Int(rand.Reader, max)
+		s[i] = r[n.Int64()]
+	}
+	return string(s)
+}
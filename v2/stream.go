@@ -0,0 +1,199 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+)
+
+// StreamFormat is the framing of a stream of JSON values
+type StreamFormat int
+
+const (
+	// NDJSON writes one JSON value per line, as application/x-ndjson
+	NDJSON StreamFormat = iota
+	// JSONSeq writes RFC 7464 JSON text sequences, as application/json-seq: each value is preceded by
+	// a record separator and followed by a line feed
+	JSONSeq
+)
+
+// recordSeparator starts each value of a JSON text sequence
+const recordSeparator = 0x1e
+
+// defaultStreamFlushInterval is how often streams are flushed when Tools.StreamFlushInterval is not set
+const defaultStreamFlushInterval = time.Second
+
+func (f StreamFormat) contentType() string {
+	if f == JSONSeq {
+		return "application/json-seq"
+	}
+	return "application/x-ndjson"
+}
+
+// delimiter returns the byte that separates the values of the stream
+func (f StreamFormat) delimiter() byte {
+	if f == JSONSeq {
+		return recordSeparator
+	}
+	return '\n'
+}
+
+// StreamError reports an error reading the value on a line of an NDJSON stream, or in a record of a JSON
+// text sequence, counting from 1
+type StreamError struct {
+	Line int
+	Err  error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// WriteJSONStream writes the items of seq to the client as they are produced, as NDJSON unless another
+// format is given. The response is flushed after the first item, then every StreamFlushInterval (one
+// second by default) and at the end. If the first item cannot be encoded, nothing is written, so that
+// an error response can still be sent; later errors, including a client that went away, stop the
+// stream and are returned.
+func WriteJSONStream[T any](tools *Tools, w http.ResponseWriter, status int, seq iter.Seq[T], format ...StreamFormat) error {
+	f := NDJSON
+	if len(format) > 0 {
+		f = format[0]
+	}
+	flusher := newStreamFlusher(tools, w)
+	var buf bytes.Buffer
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", f.contentType())
+			w.Header().Del("Content-Length")
+			w.WriteHeader(status)
+		}
+	}
+	for item := range seq {
+		out, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		buf.Reset()
+		if f == JSONSeq {
+			buf.WriteByte(recordSeparator)
+		}
+		buf.Write(out)
+		buf.WriteByte('\n')
+		start()
+		if _, err = w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		flusher.maybeFlush()
+	}
+	start()
+	flusher.flush()
+	return nil
+}
+
+// streamFlusher flushes a streamed response after its first item, then every StreamFlushInterval
+type streamFlusher struct {
+	rc        *http.ResponseController
+	interval  time.Duration
+	lastFlush time.Time
+}
+
+func newStreamFlusher(tools *Tools, w http.ResponseWriter) *streamFlusher {
+	return &streamFlusher{
+		rc:       http.NewResponseController(w),
+		interval: cmp.Or(tools.StreamFlushInterval, defaultStreamFlushInterval),
+	}
+}
+
+// maybeFlush flushes the response if the interval has elapsed since the last flush
+func (f *streamFlusher) maybeFlush() {
+	if time.Since(f.lastFlush) >= f.interval {
+		f.flush()
+	}
+}
+
+func (f *streamFlusher) flush() {
+	_ = f.rc.Flush()
+	f.lastFlush = time.Now()
+}
+
+// ReadJSONStream reads the NDJSON body of r, or one in another format, one value at a time. Each value
+// may be up to MaxJSONSize bytes (1 MiB by default), whatever the size of the whole body, and is read
+// like ReadJSON reads a body, with the same error messages wrapped in a StreamError that gives the
+// line of the value. Blank lines are skipped. Iteration stops after the first error.
+func ReadJSONStream[T any](tools *Tools, r *http.Request, format ...StreamFormat) iter.Seq2[T, error] {
+	f := NDJSON
+	if len(format) > 0 {
+		f = format[0]
+	}
+	maxBytes := 1 << 20
+	if tools.MaxJSONSize != 0 {
+		maxBytes = tools.MaxJSONSize
+	}
+	codec := JSONCodec{AllowUnknownFields: tools.AllowUnknownFields}
+	return func(yield func(T, error) bool) {
+		var zero T
+		br := bufio.NewReader(r.Body)
+		line := 0
+		for {
+			record, err := readRecord(br, f.delimiter(), maxBytes)
+			line++
+			if err == io.EOF && len(bytes.TrimSpace(record)) == 0 {
+				return
+			}
+			if err != nil && err != io.EOF {
+				yield(zero, &StreamError{Line: line, Err: decodeError(err, codec.Name(), maxBytes)})
+				return
+			}
+			if f == JSONSeq && line == 1 && len(bytes.TrimSpace(record)) == 0 {
+				// the first separator opens the first record; nothing comes before it
+				line--
+				continue
+			}
+			if len(bytes.TrimSpace(record)) == 0 {
+				continue
+			}
+			var item T
+			if decodeErr := codec.Decode(bytes.NewReader(record), &item); decodeErr != nil {
+				yield(zero, &StreamError{Line: line, Err: decodeError(decodeErr, codec.Name(), maxBytes)})
+				return
+			}
+			if !yield(item, nil) || err == io.EOF {
+				return
+			}
+		}
+	}
+}
+
+// readRecord reads up to the next delimiter, which is not returned, failing with an *http.MaxBytesError
+// if more than maxBytes come before it. At the end of the input it returns the last record with io.EOF.
+func readRecord(br *bufio.Reader, delimiter byte, maxBytes int) ([]byte, error) {
+	var record []byte
+	for {
+		chunk, err := br.ReadSlice(delimiter)
+		if len(record)+len(chunk) > maxBytes+1 {
+			return nil, &http.MaxBytesError{Limit: int64(maxBytes)}
+		}
+		record = append(record, chunk...)
+		switch {
+		case err == nil:
+			return record[:len(record)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		default:
+			return record, err
+		}
+	}
+}
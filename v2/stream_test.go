@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testEvent struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestWriteJSONStream(t *testing.T) {
+	var testTools Tools
+	events := []testEvent{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}
+
+	rr := httptest.NewRecorder()
+	if err := WriteJSONStream(&testTools, rr, http.StatusOK, slices.Values(events)); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Header().Get("Content-Type") != "application/x-ndjson" || !rr.Flushed {
+		t.Errorf("unexpected response %v, flushed %t", rr.Header(), rr.Flushed)
+	}
+	if rr.Body.String() != "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n" {
+		t.Errorf("unexpected body %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	if err := WriteJSONStream(&testTools, rr, http.StatusOK, slices.Values(events), JSONSeq); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Header().Get("Content-Type") != "application/json-seq" || rr.Body.String() != "\x1e{\"id\":1,\"name\":\"a\"}\n\x1e{\"id\":2,\"name\":\"b\"}\n" {
+		t.Errorf("unexpected JSON sequence %v %q", rr.Header(), rr.Body.String())
+	}
+
+	// an empty stream is a valid, empty, response
+	rr = httptest.NewRecorder()
+	if err := WriteJSONStream(&testTools, rr, http.StatusOK, slices.Values([]testEvent{})); err != nil || rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("unexpected response to an empty stream: %d %q %v", rr.Code, rr.Body.String(), err)
+	}
+}
+
+func TestWriteJSONStream_Errors(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	err := WriteJSONStream(&testTools, rr, http.StatusOK, slices.Values([]interface{}{make(chan int)}))
+	if err == nil || rr.Body.Len() != 0 || rr.Header().Get("Content-Type") != "" {
+		t.Errorf("expected nothing to be written when the first item fails, got %q (%v)", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	err = WriteJSONStream(&testTools, rr, http.StatusOK, slices.Values([]interface{}{1, make(chan int), 3}))
+	if err == nil || rr.Body.String() != "1\n" {
+		t.Errorf("expected the stream to stop at the failing item, got %q (%v)", rr.Body.String(), err)
+	}
+
+	// the producer stops when the client goes away
+	produced := 0
+	seq := func(yield func(int) bool) {
+		for i := 0; i < 100; i++ {
+			produced++
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	err = WriteJSONStream(&testTools, failingWriter{httptest.NewRecorder()}, http.StatusOK, iter.Seq[int](seq))
+	if err == nil || produced != 1 {
+		t.Errorf("expected the stream to stop after the first failed write, produced %d (%v)", produced, err)
+	}
+}
+
+// failingWriter fails every write, like a connection the client closed
+type failingWriter struct {
+	http.ResponseWriter
+}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+var readJSONStreamTests = []struct {
+	name       string
+	body       string
+	format     StreamFormat
+	maxSize    int
+	expected   []testEvent
+	expectErr  string
+	expectLine int
+}{
+	{name: "ndjson", body: "{\"id\":1,\"name\":\"a\"}\n\n{\"id\":2,\"name\":\"b\"}", expected: []testEvent{{1, "a"}, {2, "b"}}},
+	{name: "crlf", body: "{\"id\":1}\r\n{\"id\":2}\r\n", expected: []testEvent{{ID: 1}, {ID: 2}}},
+	{name: "empty", body: ""},
+	{name: "json-seq", body: "\x1e{\"id\":1}\n\x1e{\"id\":2}\n", format: JSONSeq, expected: []testEvent{{ID: 1}, {ID: 2}}},
+	{name: "badly-formed", body: "{\"id\":1}\n{\"id\":2,}\n{\"id\":3}", expected: []testEvent{{ID: 1}},
+		expectErr: "line 2: body contains badly-formed JSON (at character 9)", expectLine: 2},
+	{name: "wrong type", body: "{\"id\":\"one\"}", expectErr: `line 1: body contains incorrect JSON type for field "id"`, expectLine: 1},
+	{name: "unknown key", body: "{\"id\":1}\n\n{\"size\":1}", expected: []testEvent{{ID: 1}}, expectErr: `line 3: body contains unknown key "size"`, expectLine: 3},
+	{name: "two values on a line", body: "{\"id\":1} {\"id\":2}", expectErr: "line 1: body must contain only one JSON value", expectLine: 1},
+	{name: "truncated", body: "{\"id\":1", expectErr: "line 1: body contains badly-formed JSON", expectLine: 1},
+	{name: "item too large", body: "{\"id\":1}\n{\"name\":\"" + strings.Repeat("x", 100) + "\"}\n", maxSize: 50, expected: []testEvent{{ID: 1}},
+		expectErr: "line 2: body must not be larger than 50 bytes", expectLine: 2},
+	{name: "json-seq error", body: "\x1e{\"id\":1}\n\x1e{\"id\":\n", format: JSONSeq, expected: []testEvent{{ID: 1}}, expectErr: "line 2: body contains badly-formed JSON", expectLine: 2},
+}
+
+func TestReadJSONStream(t *testing.T) {
+	for _, e := range readJSONStreamTests {
+		// the whole body may be larger than the limit, only items are limited
+		testTools := Tools{MaxJSONSize: e.maxSize}
+		if e.maxSize == 0 {
+			testTools.MaxJSONSize = 20
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(e.body))
+		var got []testEvent
+		var err error
+		for item, itemErr := range ReadJSONStream[testEvent](&testTools, r, e.format) {
+			if itemErr != nil {
+				err = itemErr
+				continue
+			}
+			got = append(got, item)
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+		var streamErr *StreamError
+		switch {
+		case e.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		case e.expectErr != "" && (err == nil || err.Error() != e.expectErr):
+			t.Errorf("%s: expected error %q, got %v", e.name, e.expectErr, err)
+		case e.expectErr != "" && (!errors.As(err, &streamErr) || streamErr.Line != e.expectLine):
+			t.Errorf("%s: expected a StreamError on line %d, got %#v", e.name, e.expectLine, err)
+		}
+	}
+}
+
+func TestReadJSONStream_Break(t *testing.T) {
+	var testTools Tools
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("1\n2\n3\n"))
+	var got []int
+	for item, err := range ReadJSONStream[int](&testTools, r) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item)
+		if item == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", got)
+	}
+}
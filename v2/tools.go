@@ -2,10 +2,13 @@ package toolkit
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -13,6 +16,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+"
@@ -20,10 +24,36 @@ const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ
 // Tools is the type used to instantiate this module. Any variable of this type will have access
 // to all the methods with the receiver *Tools
 type Tools struct {
-	MaxFileSize        int
-	AllowedFileTypes   []string
-	MaxJSONSize        int
-	AllowUnknownFields bool
+	MaxFileSize             int
+	AllowedFileTypes        []string
+	MaxJSONSize             int
+	AllowUnknownFields      bool
+	DownloadRoot            string
+	SVGMode                 SVGMode
+	RenameStrategy          RenameStrategy
+	RenameFunc              RenameFunc
+	Quarantine              *Quarantine
+	Quota                   *Quota
+	WriteMetadata           bool
+	MetadataAttributes      func(r *http.Request, file *UploadedFile) map[string]string
+	ETags                   bool
+	CacheControl            string
+	DownloadThrottle        *Throttle
+	UploadThrottle          *Throttle
+	DownloadAuthorizer      DownloadAuthorizer
+	DownloadAudit           func(rec DownloadRecord)
+	TrustProxyHeaders       bool
+	ImageSizes              []ImageSize
+	ImageCacheDir           string
+	Precompressed           bool
+	CompressMinSize         int64
+	ProblemDetails          bool
+	ProblemTypeBase         string
+	Codecs                  []Codec
+	CompressResponseMinSize int
+	StreamFlushInterval     time.Duration
+	SSEKeepAliveInterval    time.Duration
+	RequireJSONContentType  bool
 }
 
 // RandomString returns a strings
@@ -39,26 +69,43 @@ func (tools *Tools) RandomString(length int) string {
 
 // UploadedFile is a struct used to save information about an uploaded file
 type UploadedFile struct {
+	ID               string
 	NewFileName      string
 	OriginalFileName string
 	FileSize         int64
+	Owner            string
+	ContentType      string
+	Checksum         string
 }
 
 // UploadOneFile upload one file in specific directory
 func (tools *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return tools.UploadOneFileContext(r.Context(), r, uploadDir, rename...)
+}
+
+// UploadOneFileContext is like UploadOneFile, but stops as soon as ctx is done
+func (tools *Tools) UploadOneFileContext(ctx context.Context, r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
 	renameFile := true
 	if len(rename) > 0 {
 		renameFile = rename[0]
 	}
-	files, err := tools.UploadFiles(r, uploadDir, renameFile)
+	files, err := tools.UploadFilesContext(ctx, r, uploadDir, renameFile)
 	if err != nil {
 		return nil, err
 	}
 	return files[0], nil
 }
 
-// UploadFiles upload multiple files in specific directory
+// UploadFiles upload multiple files in specific directory. When a Quarantine is set, files are written
+// to the quarantine directory instead and moved to uploadDir once its processors accept them; the ID of
+// each returned file can be used to query its status.
 func (tools *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	return tools.UploadFilesContext(r.Context(), r, uploadDir, rename...)
+}
+
+// UploadFilesContext is like UploadFiles, but stops copying as soon as ctx is done, removing the
+// partially written file
+func (tools *Tools) UploadFilesContext(ctx context.Context, r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
 	renameFile := true
 	if len(rename) > 0 {
 		renameFile = rename[0]
@@ -71,12 +118,30 @@ func (tools *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...boo
 	if err != nil {
 		return nil, err
 	}
+	var owner string
+	if tools.Quota != nil {
+		if owner, err = tools.Quota.owner(r); err != nil {
+			return nil, err
+		}
+		if err = tools.Quota.check(owner); err != nil {
+			return nil, err
+		}
+	}
+	if tools.Quarantine != nil && !tools.Quarantine.started() {
+		return nil, ErrQuarantineNotStarted
+	}
 	err = r.ParseMultipartForm(int64(tools.MaxFileSize))
 	if err != nil {
-		return nil, errors.New("the uploaded file is too big")
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, ErrFileTooBig
 	}
 	for _, fHeaders := range r.MultipartForm.File {
 		for _, hdr := range fHeaders {
+			if err = ctx.Err(); err != nil {
+				return uploadedFiles, err
+			}
 			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
 				var uploadedFile UploadedFile
 				infile, err := hdr.Open()
@@ -87,13 +152,14 @@ func (tools *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...boo
 					_ = infile.Close()
 				}(infile)
 				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
-				if err != nil {
+				n, err := infile.Read(buff)
+				if err != nil && err != io.EOF {
 					return nil, err
 				}
+				buff = buff[:n]
 
 				allowed := false
-				fileType := http.DetectContentType(buff)
+				fileType := detectContentType(buff)
 				if len(tools.AllowedFileTypes) > 0 {
 					for _, t := range tools.AllowedFileTypes {
 						if strings.EqualFold(fileType, t) {
@@ -104,34 +170,96 @@ func (tools *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...boo
 					allowed = true
 				}
 				if !allowed {
-					return nil, errors.New("the uploaded file type is not permitted")
+					return nil, ErrFileTypeNotPermitted
 				}
 
 				_, err = infile.Seek(0, io.SeekStart)
 				if err != nil {
 					return nil, err
 				}
+				var src io.ReadSeeker = infile
+				if fileType == "image/svg+xml" {
+					var clean bytes.Buffer
+					removed, err := tools.SanitizeSVG(&clean, infile)
+					if err != nil {
+						return nil, &UnsafeSVGError{FileName: hdr.Filename, Reasons: []string{err.Error()}}
+					}
+					if len(removed) > 0 && tools.SVGMode == SVGReject {
+						return nil, &UnsafeSVGError{FileName: hdr.Filename, Reasons: removed}
+					}
+					src = bytes.NewReader(clean.Bytes())
+				}
 				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf(
-						"%s%s",
-						tools.RandomString(25),
-						filepath.Ext(hdr.Filename))
+					uploadedFile.NewFileName, err = tools.newFileName(hdr.Filename, src)
+					if err != nil {
+						return nil, err
+					}
 				} else {
 					uploadedFile.NewFileName = hdr.Filename
 				}
 				uploadedFile.OriginalFileName = hdr.Filename
-				var outfile *os.File
-				defer func(outfile *os.File) {
-					_ = outfile.Close()
-				}(outfile)
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
+				uploadedFile.Owner = owner
+				var body io.Reader = &contextReader{ctx: ctx, r: src}
+				if limiters := tools.UploadThrottle.limiters(r, 0); len(limiters) > 0 {
+					body = &throttledReader{ctx: ctx, r: body, limiters: limiters, chunk: throttleChunk(limiters)}
+				}
+				var reservation *quotaReader
+				if tools.Quota != nil {
+					if reservation, err = tools.Quota.reader(owner, body); err != nil {
+						return nil, err
+					}
+					body = reservation
+				}
+				dst := filepath.Join(uploadDir, uploadedFile.NewFileName)
+				if tools.Quarantine != nil {
+					if uploadedFile.ID, err = newUUID(); err != nil {
+						return nil, err
+					}
+					dst = tools.Quarantine.path(uploadedFile.ID)
+				}
+				uploadedFile.ContentType = fileType
+				var replaced *replacedFile
+				if tools.Quarantine == nil {
+					// the file stored under the same name is truncated as soon as the upload is written
+					replaced = tools.Quota.replaced(dst)
+					replaced.release()
+				}
+				if uploadedFile.FileSize, uploadedFile.Checksum, err = storeFile(dst, body); err != nil {
+					if _, statErr := os.Stat(dst); statErr == nil {
+						// it could not be opened, and is still there
+						replaced.restore()
+					}
+					if reservation != nil {
+						reservation.cancel()
+					}
 					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
+				}
+				if reservation != nil {
+					if err = reservation.commit(uploadedFile.FileSize); err != nil {
+						_ = os.Remove(dst)
+						reservation.cancel()
 						return nil, err
 					}
-					uploadedFile.FileSize = fileSize
+				}
+				var meta *FileMetadata
+				if tools.WriteMetadata {
+					meta = &FileMetadata{UploadedAt: time.Now().UTC()}
+					if tools.MetadataAttributes != nil {
+						meta.Attributes = tools.MetadataAttributes(r, &uploadedFile)
+					}
+				}
+				if tools.Quarantine != nil {
+					err = tools.Quarantine.enqueue(uploadedFile, uploadDir, tools.Quota, meta)
+				} else if meta != nil {
+					meta.UploadedFile = uploadedFile
+					err = writeMetadata(uploadDir, meta)
+				}
+				if err != nil {
+					_ = os.Remove(dst)
+					if tools.Quota != nil {
+						_ = tools.Quota.Release(owner, uploadedFile.FileSize)
+					}
+					return nil, err
 				}
 				uploadedFiles = append(uploadedFiles, &uploadedFile)
 				return uploadedFiles, nil
@@ -144,6 +272,52 @@ func (tools *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...boo
 	return uploadedFiles, nil
 }
 
+// contextReader stops reading with the context's error once it is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// storeFile writes the content of src to a new file at path, removing it again if anything fails.
+// It returns the size and the hex encoded SHA-256 of what was written.
+func storeFile(path string, src io.Reader) (int64, string, error) {
+	outfile, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	fileSize, err := io.Copy(io.MultiWriter(outfile, h), src)
+	if closeErr := outfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, "", err
+	}
+	return fileSize, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// detectContentType sniffs the content type of a file from its first bytes. It extends
+// http.DetectContentType, which reports SVG documents as plain text or XML, or as HTML when they
+// start with a comment.
+func detectContentType(buff []byte) string {
+	fileType := http.DetectContentType(buff)
+	if (strings.HasPrefix(fileType, "text/xml") || strings.HasPrefix(fileType, "text/plain")) && looksLikeSVG(buff) {
+		return "image/svg+xml"
+	}
+	if strings.HasPrefix(fileType, "text/html") && startsWithSVG(buff) {
+		return "image/svg+xml"
+	}
+	return fileType
+}
+
 // CreateDirIfNotExist creates a directory, and all necessary parents, if it does not exist
 func (tools *Tools) CreateDirIfNotExist(path string) error {
 	const mode = 0755
@@ -169,10 +343,91 @@ func (tools *Tools) Slugify(s string) (string, error) {
 	return slug, nil
 }
 
-// DownloadStaticFile downloads a file
+// DownloadStaticFile downloads a file. The file is confined to DownloadRoot, or to the working directory
+// when it is not set: relative paths are resolved against it, and paths that lead outside of it, including
+// through symbolic links, and hidden files are answered with 404 Not Found. If displayName is empty, the
+// original file name and content type are taken from the metadata sidecar of the file, when it has one.
 func (tools *Tools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, pathName, displayName string) {
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+displayName+"\"")
-	http.ServeFile(w, r, pathName)
+	tools.DownloadStaticFileWithOptions(w, r, pathName, DownloadOptions{DisplayName: displayName})
+}
+
+// DownloadStaticFileWithOptions is like DownloadStaticFile, with control over the display name,
+// the disposition and the content type of the response
+func (tools *Tools) DownloadStaticFileWithOptions(w http.ResponseWriter, r *http.Request, pathName string, opts DownloadOptions) {
+	root, err := tools.openDownloadRoot()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func(root *os.Root) {
+		_ = root.Close()
+	}(root)
+	tools.serveFile(w, r, root.FS(), absPath(cmp.Or(tools.DownloadRoot, ".")), tools.downloadName(pathName), opts)
+}
+
+// ServeImageVariant serves the PNG or JPEG image pathName, confined to DownloadRoot like DownloadStaticFile,
+// resized according to the query parameters w and h (in pixels), fit (contain, cover or fill), fmt (jpeg
+// or png) and q (the JPEG quality, from 1 to 100). Only the sizes listed in ImageSizes are allowed. Variants
+// are cached on disk in ImageCacheDir, or in a directory under the system temporary directory when it is
+// not set.
+func (tools *Tools) ServeImageVariant(w http.ResponseWriter, r *http.Request, pathName string) {
+	root, err := tools.openDownloadRoot()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func(root *os.Root) {
+		_ = root.Close()
+	}(root)
+	tools.serveImageVariant(w, r, root.FS(), absPath(cmp.Or(tools.DownloadRoot, ".")), tools.downloadName(pathName))
+}
+
+// DownloadZip streams the files listed in entries, confined to DownloadRoot like DownloadStaticFile, to the
+// client as a single zip archive named archiveName. Files that are already compressed are stored as is, as
+// are all files when storeOnly is true; duplicate names inside the archive are numbered.
+func (tools *Tools) DownloadZip(w http.ResponseWriter, r *http.Request, entries []ZipEntry, archiveName string, storeOnly ...bool) {
+	root, err := tools.openDownloadRoot()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer func(root *os.Root) {
+		_ = root.Close()
+	}(root)
+	rooted := make([]ZipEntry, len(entries))
+	for i, e := range entries {
+		rooted[i] = ZipEntry{Path: tools.downloadName(e.Path), Name: e.Name}
+	}
+	tools.serveZip(w, r, root.FS(), rooted, archiveName, len(storeOnly) > 0 && storeOnly[0])
+}
+
+// openDownloadRoot opens DownloadRoot, or the working directory when it is not set
+func (tools *Tools) openDownloadRoot() (*os.Root, error) {
+	if tools.DownloadRoot == "" {
+		return os.OpenRoot(".")
+	}
+	return os.OpenRoot(tools.DownloadRoot)
+}
+
+// downloadName returns the path of pathName relative to the download root. Absolute paths outside of
+// the root come out starting with "..", and are then refused like any other path leaving the root.
+func (tools *Tools) downloadName(pathName string) string {
+	if !filepath.IsAbs(pathName) {
+		return pathName
+	}
+	dir := tools.DownloadRoot
+	if dir == "" {
+		dir = "."
+	}
+	absRoot, err := filepath.Abs(dir)
+	if err != nil {
+		return ".."
+	}
+	rel, err := filepath.Rel(absRoot, pathName)
+	if err != nil {
+		return ".."
+	}
+	return rel
 }
 
 // JSONResponse is the type used for sending JSON around
@@ -182,54 +437,32 @@ type JSONResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// ReadJSON is a helper function to read JSON from a request
+// ReadJSON is a helper function to read JSON from a request. Gzip-encoded bodies are decompressed. With
+// RequireJSONContentType set, requests without a JSON Content-Type get an UnsupportedMediaTypeError.
 func (tools *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1 << 20
-	if tools.MaxJSONSize != 0 {
-		maxBytes = tools.MaxJSONSize
-	}
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
-	if !tools.AllowUnknownFields {
-		dec.DisallowUnknownFields()
-	}
-	err := dec.Decode(data)
-	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field:")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshaling JSON: %s", invalidUnmarshalError.Error())
-		default:
+	if tools.RequireJSONContentType {
+		if err := checkJSONContentType(r); err != nil {
 			return err
 		}
 	}
-	err = dec.Decode(&struct{}{})
-	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
-	}
-	return nil
+	return tools.readBody(w, r, JSONCodec{AllowUnknownFields: tools.AllowUnknownFields}, data)
 }
 
-// WriteJSON takes a response status code and arbitrary data and writes json to the client
+// WriteJSON takes a response status code and arbitrary data and writes json to the client. As it does
+// not see the request, the body is only compressed under the Compress middleware; use WriteJSONRequest
+// to compress it without the middleware.
 func (tools *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
+	return tools.writeJSON(w, nil, status, "application/json", data, headers...)
+}
+
+// WriteJSONRequest is like WriteJSON, but with CompressResponseMinSize set, bodies of at least that many
+// bytes are compressed as the Accept-Encoding header of r allows, with or without the Compress middleware
+func (tools *Tools) WriteJSONRequest(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers ...http.Header) error {
+	return tools.writeJSON(w, r, status, "application/json", data, headers...)
+}
+
+// writeJSON implements WriteJSON, sending data with the given content type. r may be nil.
+func (tools *Tools) writeJSON(w http.ResponseWriter, r *http.Request, status int, contentType string, data interface{}, headers ...http.Header) error {
 	out, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -239,7 +472,8 @@ func (tools *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{
 			w.Header()[k] = v
 		}
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
+	out = tools.compressBody(w, r, out)
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -248,9 +482,49 @@ func (tools *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{
 	return nil
 }
 
-// ErrorJSON takes an error, and optionally a status code, and generates and sends a JSON error message
+// ErrorJSON takes an error, and optionally a status code, and generates and sends a JSON error message.
+// ValidationErrors default to status 422 and are listed in the data of the message.
+//
+// With ProblemDetails set, an RFC 9457 problem details document is sent instead. A Problem, or an error
+// wrapping one, is sent as is; typed toolkit errors, such as ValidationErrors or QuotaExceededError, get
+// a matching status and a problem type under ProblemTypeBase; other errors get an about:blank problem.
+//
+// Like WriteJSON, ErrorJSON only compresses the message under the Compress middleware; see ErrorJSONRequest.
 func (tools *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
+	return tools.errorJSON(w, nil, err, status...)
+}
+
+// ErrorJSONRequest is like ErrorJSON, but compresses the message as WriteJSONRequest does
+func (tools *Tools) ErrorJSONRequest(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	return tools.errorJSON(w, r, err, status...)
+}
+
+// errorJSON implements ErrorJSON. r may be nil.
+func (tools *Tools) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	if tools.ProblemDetails {
+		statusCode := 0
+		if len(status) > 0 {
+			statusCode = status[0]
+		}
+		problem := tools.problemFor(err, statusCode)
+		return tools.writeJSON(w, r, problem.Status, ProblemContentType, problem)
+	}
 	statusCode := http.StatusBadRequest
+	var validationErrors ValidationErrors
+	var unsupportedMediaType *UnsupportedMediaTypeError
+	var unsupportedEncoding *UnsupportedEncodingError
+	var patchError *PatchError
+	isValidation := errors.As(err, &validationErrors)
+	switch {
+	case isValidation:
+		statusCode = http.StatusUnprocessableEntity
+	case errors.As(err, &unsupportedMediaType), errors.As(err, &unsupportedEncoding):
+		statusCode = http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrPatchTestFailed):
+		statusCode = http.StatusConflict
+	case errors.As(err, &patchError):
+		statusCode = http.StatusUnprocessableEntity
+	}
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
@@ -258,11 +532,19 @@ func (tools *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) e
 		Error:   true,
 		Message: err.Error(),
 	}
-	return tools.WriteJSON(w, statusCode, payload)
+	if isValidation {
+		payload.Data = validationErrors
+	}
+	return tools.writeJSON(w, r, statusCode, "application/json", payload)
 }
 
 // PushJSONToRemote posts JSON to a remote URL
 func (tools *Tools) PushJSONToRemote(url string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
+	return tools.PushJSONToRemoteContext(context.Background(), url, data, client...)
+}
+
+// PushJSONToRemoteContext is like PushJSONToRemote, but aborts the call as soon as ctx is done
+func (tools *Tools) PushJSONToRemoteContext(ctx context.Context, url string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, 0, err
@@ -271,7 +553,7 @@ func (tools *Tools) PushJSONToRemote(url string, data interface{}, client ...*ht
 	if len(client) > 0 {
 		httpClient = client[0]
 	}
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, 0, err
 	}
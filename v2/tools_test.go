@@ -2,6 +2,8 @@ package toolkit
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,8 +14,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type RoundTripFunc func(req *http.Request) *http.Response
@@ -47,6 +52,25 @@ func TestTools_PushJSONToRemote(t *testing.T) {
 	}
 }
 
+func TestTools_PushJSONToRemoteContext(t *testing.T) {
+	client := &http.Client{Transport: blockingTransport{}}
+	var testTools Tools
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := testTools.PushJSONToRemoteContext(ctx, "http://example.com/some/path", map[string]string{"foo": "bar"}, client)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the call to be aborted by the context, got %v", err)
+	}
+}
+
+// blockingTransport never answers, until the request's context is done
+type blockingTransport struct{}
+
+func (blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
 func TestTools_RandomString(t *testing.T) {
 	var testTools Tools
 	s := testTools.RandomString(10)
@@ -184,6 +208,29 @@ func TestTools_UploadOneFile(t *testing.T) {
 	})
 }
 
+func TestTools_UploadFilesContext(t *testing.T) {
+	uploadDir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	testTools := Tools{RenameFunc: func(originalName string, content io.Reader) (string, error) {
+		// the client goes away after the upload was parsed, before the file is copied
+		cancel()
+		return "cancelled.txt", nil
+	}}
+	request := newUploadRequest(t, "foo.txt", []byte("foo"))
+	_, err := testTools.UploadFilesContext(ctx, request, uploadDir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(uploadDir, "cancelled.txt")); !os.IsNotExist(err) {
+		t.Error("expected the partial file to be removed")
+	}
+
+	if _, err = testTools.UploadOneFileContext(ctx, newUploadRequest(t, "foo.txt", []byte("foo")), uploadDir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled for a context already done, got %v", err)
+	}
+}
+
 func TestTools_CreateDirIfNotExist(t *testing.T) {
 	var testTool Tools
 	err := testTool.CreateDirIfNotExist("./testdata/myDir")
@@ -253,6 +300,59 @@ func TestTools_DownloadStaticFile(t *testing.T) {
 	})
 }
 
+func TestTools_DownloadStaticFile_Confined(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "files")
+	if err := os.MkdirAll(filepath.Join(base, ".hidden"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(dir, "secret.txt"):              "secret",
+		filepath.Join(base, ".env"):                   "secret",
+		filepath.Join(base, ".hidden", "file.txt"):    "secret",
+		filepath.Join(base, "public.txt"):             "public",
+		filepath.Join(base, "public.txt.meta.json"):   `{"owner":"secret"}`,
+		filepath.Join(base, "sub", "nested.txt"):      "nested",
+		filepath.Join(base, "sub", "..", "other.txt"): "other",
+	} {
+		_ = os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(base, "escape.txt")); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+
+	testTool := Tools{DownloadRoot: base}
+	for file, expected := range map[string]int{
+		"public.txt":                      http.StatusOK,
+		"sub/nested.txt":                  http.StatusOK,
+		"./sub/../other.txt":              http.StatusOK,
+		"../secret.txt":                   http.StatusNotFound,
+		"sub/../../secret.txt":            http.StatusNotFound,
+		"/etc/passwd":                     http.StatusNotFound,
+		"escape.txt":                      http.StatusNotFound,
+		".env":                            http.StatusNotFound,
+		".hidden/file.txt":                http.StatusNotFound,
+		"public.txt.meta.json":            http.StatusNotFound,
+		"missing.txt":                     http.StatusNotFound,
+		filepath.Join(base, "public.txt"): http.StatusOK,
+		filepath.Join(dir, "secret.txt"):  http.StatusNotFound,
+		"sub":                             http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		testTool.DownloadStaticFile(w, r, file, "download.txt")
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", file, expected, w.Code)
+		}
+		if w.Code == http.StatusNotFound && (strings.Contains(w.Body.String(), "secret") || w.Header().Get("Content-Disposition") != "") {
+			t.Errorf("%s: 404 response leaks the file: %q", file, w.Body.String())
+		}
+	}
+}
+
 var jsonTests = []struct {
 	name          string
 	json          string
@@ -297,6 +397,97 @@ func TestTools_ReadJSON(t *testing.T) {
 	}
 }
 
+func TestTools_ReadJSON_ContentType(t *testing.T) {
+	testTool := Tools{RequireJSONContentType: true}
+	for contentType, accepted := range map[string]bool{
+		"application/json":                  true,
+		"application/json; charset=UTF-8":   true,
+		"application/json;charset=utf8":     true,
+		"application/problem+json":          true,
+		"application/vnd.api+json":          true,
+		"application/json; charset=latin1":  false,
+		"application/x-www-form-urlencoded": false,
+		"text/plain":                        false,
+		"application/jsonx":                 false,
+		"application/json; charset":         false,
+		"":                                  false,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"foo": "bar"}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		var decodedJSON struct {
+			Foo string `json:"foo"`
+		}
+		err := testTool.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+		if accepted && (err != nil || decodedJSON.Foo != "bar") {
+			t.Errorf("%q: expected the body to be read, got %v", contentType, err)
+		}
+		var unsupported *UnsupportedMediaTypeError
+		if !accepted && !errors.As(err, &unsupported) {
+			t.Errorf("%q: expected an UnsupportedMediaTypeError, got %v", contentType, err)
+		}
+	}
+
+	// the check is off by default
+	var lenient Tools
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "text/plain")
+	var decodedJSON struct{}
+	if err := lenient.ReadJSON(httptest.NewRecorder(), req, &decodedJSON); err != nil {
+		t.Error(err)
+	}
+
+	rr := httptest.NewRecorder()
+	_ = testTool.ErrorJSON(rr, &UnsupportedMediaTypeError{ContentType: "text/plain"})
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", rr.Code)
+	}
+}
+
+func TestTools_ReadJSON_Gzip(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write([]byte(s))
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+	large := `{"foo": "` + strings.Repeat("a", 2000) + `"}`
+
+	var tests = []struct {
+		name     string
+		encoding string
+		body     []byte
+		expected string
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipped(`{"foo": "bar"}`)},
+		{name: "x-gzip", encoding: "X-Gzip", body: gzipped(`{"foo": "bar"}`)},
+		{name: "identity", encoding: "identity", body: []byte(`{"foo": "bar"}`)},
+		// the limit applies to the decompressed body, however well it compresses
+		{name: "too large", encoding: "gzip", body: gzipped(large), expected: "body must not be larger than 1024 bytes"},
+		{name: "not gzip", encoding: "gzip", body: []byte(`{"foo": "bar"}`), expected: "body contains badly-formed gzip data"},
+		{name: "corrupt", encoding: "gzip", body: append(gzipped(`{"foo": "bar"}`)[:12], 0xff, 0xff, 0xff, 0xff), expected: "body contains badly-formed gzip data"},
+		{name: "empty", encoding: "gzip", body: nil, expected: "body must not be empty"},
+		{name: "brotli", encoding: "br", body: []byte("x"), expected: `unsupported content encoding "br"`},
+	}
+	testTool := Tools{MaxJSONSize: 1024}
+	for _, e := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(e.body))
+		req.Header.Set("Content-Encoding", e.encoding)
+		var decodedJSON struct {
+			Foo string `json:"foo"`
+		}
+		err := testTool.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+		if e.expected == "" && (err != nil || decodedJSON.Foo != "bar") {
+			t.Errorf("%s: expected the body to be read, got %v", e.name, err)
+		}
+		if e.expected != "" && (err == nil || err.Error() != e.expected) {
+			t.Errorf("%s: expected error %q, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
 func TestTools_WriteJSON(t *testing.T) {
 	var testTool Tools
 	rr := httptest.NewRecorder()
@@ -0,0 +1,263 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a field that failed validation
+type FieldError struct {
+	// Field is the JSON path of the field, such as "address.city" or "items[2].name"
+	Field string `json:"field"`
+	// Rule is the validation rule that failed, such as "required" or "max"
+	Rule string `json:"rule"`
+	// Param is the parameter of the rule, such as "50" for max=50
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationErrors lists every field of a value that failed validation
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ReadAndValidateJSON reads JSON from a request into data like ReadJSON, then validates it like Validate
+func (tools *Tools) ReadAndValidateJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	if err := tools.ReadJSON(w, r, data); err != nil {
+		return err
+	}
+	return tools.Validate(data)
+}
+
+// Validate checks data, usually a pointer to a struct, against the rules in the validate tags of its
+// fields, and of the fields of nested structs, slices and maps:
+//
+//	Name  string   `json:"name" validate:"required,min=3,max=50"`
+//	Email string   `json:"email" validate:"required,email"`
+//	Role  string   `json:"role" validate:"oneof=admin editor viewer"`
+//	Tags  []string `json:"tags" validate:"max=10"`
+//
+// required fails for zero values and nil pointers. min and max bound the length of strings (in
+// characters), slices and maps, and the value of numbers. email accepts a bare address, and oneof a
+// value among a space separated list. Rules other than required are skipped for empty strings, slices
+// and maps and for nil pointers, so that optional fields only have to be valid when they are set. Numbers
+// are always checked: a zero Age with min=18 fails, as it would with oneof=1 2; use a pointer for an
+// optional number.
+//
+// All the fields that fail are returned at once as ValidationErrors, named by their JSON path. Other
+// errors mean a validate tag is malformed.
+func (tools *Tools) Validate(data interface{}) error {
+	var errs ValidationErrors
+	if err := validateValue(reflect.ValueOf(data), "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateValue walks v, whose JSON path is path, and validates the fields of the structs it contains
+func validateValue(v reflect.Value, path string, errs *ValidationErrors) error {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := validateValue(iter.Value(), fmt.Sprintf("%s[%v]", path, iter.Key()), errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateStruct applies the validate tags of the fields of the struct v
+func validateStruct(v reflect.Value, path string, errs *ValidationErrors) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		fieldPath := path
+		if !field.Anonymous || name != "" {
+			// embedded structs without a JSON name are flattened, like encoding/json does
+			if name == "" {
+				name = field.Name
+			}
+			fieldPath = joinFieldPath(path, name)
+		}
+		if err := validateField(v.Field(i), field.Tag.Get("validate"), fieldPath, errs); err != nil {
+			return err
+		}
+		if err := validateValue(v.Field(i), fieldPath, errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinFieldPath appends the field name to the JSON path of its parent
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validateField applies the comma separated rules of a validate tag to the field v
+func validateField(v reflect.Value, tag, path string, errs *ValidationErrors) error {
+	if tag == "" || tag == "-" {
+		return nil
+	}
+	rules := strings.Split(tag, ",")
+	if v.IsZero() {
+		if slices.Contains(rules, "required") {
+			*errs = append(*errs, FieldError{Field: path, Rule: "required", Message: "is required"})
+			return nil
+		}
+		// an empty string, slice or map, or a nil pointer, is an optional field left unset, while zero is
+		// a number like any other
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+		default:
+			return nil
+		}
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	for _, rule := range rules {
+		rule, param, _ := strings.Cut(rule, "=")
+		var message string
+		var err error
+		switch rule {
+		case "required", "":
+			continue
+		case "min", "max":
+			message, err = validateBound(v, rule, param)
+		case "email":
+			message, err = validateEmail(v)
+		case "oneof":
+			message, err = validateOneOf(v, param)
+		default:
+			err = fmt.Errorf("unknown rule %q", rule)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid validate tag on %s: %w", path, err)
+		}
+		if message != "" {
+			*errs = append(*errs, FieldError{Field: path, Rule: rule, Param: param, Message: message})
+		}
+	}
+	return nil
+}
+
+// validateBound checks a min or max rule, returning the message for a failure
+func validateBound(v reflect.Value, rule, param string) (string, error) {
+	bound, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return "", fmt.Errorf("%s needs a number, got %q", rule, param)
+	}
+	var value float64
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		value, unit = float64(utf8.RuneCountInString(v.String())), " characters long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		value, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = v.Float()
+	default:
+		return "", fmt.Errorf("%s does not apply to %s", rule, v.Type())
+	}
+	switch {
+	case rule == "min" && value < bound && unit == " items":
+		return "must contain at least " + param + unit, nil
+	case rule == "min" && value < bound:
+		return "must be at least " + param + unit, nil
+	case rule == "max" && value > bound && unit == " items":
+		return "must contain at most " + param + unit, nil
+	case rule == "max" && value > bound:
+		return "must be at most " + param + unit, nil
+	}
+	return "", nil
+}
+
+// validateEmail checks an email rule, returning the message for a failure
+func validateEmail(v reflect.Value) (string, error) {
+	if v.Kind() != reflect.String {
+		return "", fmt.Errorf("email does not apply to %s", v.Type())
+	}
+	address, err := mail.ParseAddress(v.String())
+	if err != nil || address.Address != v.String() || address.Name != "" {
+		return "must be a valid email address", nil
+	}
+	return "", nil
+}
+
+// validateOneOf checks a oneof rule, returning the message for a failure
+func validateOneOf(v reflect.Value, param string) (string, error) {
+	allowed := strings.Fields(param)
+	if len(allowed) == 0 {
+		return "", errors.New("oneof needs at least one value")
+	}
+	var value string
+	switch v.Kind() {
+	case reflect.String:
+		value = v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value = strconv.FormatUint(v.Uint(), 10)
+	default:
+		return "", fmt.Errorf("oneof does not apply to %s", v.Type())
+	}
+	for _, a := range allowed {
+		if a == value {
+			return "", nil
+		}
+	}
+	return "must be one of: " + strings.Join(allowed, ", "), nil
+}
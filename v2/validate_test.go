@@ -0,0 +1,133 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"min=5,max=5"`
+}
+
+type testItem struct {
+	Name     string `json:"name" validate:"required,max=10"`
+	Quantity int    `json:"quantity" validate:"min=1,max=99"`
+}
+
+type testAudit struct {
+	Source string `json:"source" validate:"oneof=web api"`
+	Retry  int    `json:"retry" validate:"oneof=0 1 2"`
+}
+
+type testSignup struct {
+	testAudit
+	Name     string            `json:"name" validate:"required,min=3,max=50"`
+	Email    string            `json:"email" validate:"required,email"`
+	Role     string            `json:"role" validate:"oneof=admin editor viewer"`
+	Level    int               `json:"level" validate:"oneof=1 2 3"`
+	Nickname *string           `json:"nickname" validate:"min=2"`
+	Address  testAddress       `json:"address"`
+	Billing  *testAddress      `json:"billing"`
+	Items    []testItem        `json:"items" validate:"required,max=3"`
+	Labels   map[string]string `json:"labels" validate:"max=2"`
+	Ignored  string            `json:"-" validate:"required"`
+}
+
+var validateTests = []struct {
+	name     string
+	json     string
+	expected []string
+}{
+	{name: "valid", json: `{"name":"Jack","email":"jack@example.com","role":"admin","level":2,"address":{"city":"Paris"},"items":[{"name":"pen","quantity":1}]}`},
+	{name: "missing fields", json: `{}`, expected: []string{"name:required", "email:required", "level:oneof", "address.city:required", "items:required"}},
+	{name: "strings", json: `{"name":"Jo","email":"Jack <jack@example.com>","role":"owner","level":1,"address":{"city":"Paris","zip":"123"},"items":[{"name":"pen","quantity":1}]}`, expected: []string{"name:min", "email:email", "role:oneof", "address.zip:min"}},
+	{name: "unicode length", json: `{"name":"Жан","email":"a@b.c","level":1,"address":{"city":"Paris"},"items":[{"name":"pen","quantity":1}]}`},
+	{name: "nested", json: `{"source":"fax","retry":5,"name":"Jack","email":"a@b.c","level":7,"nickname":"J","address":{"city":"Paris"},"billing":{"zip":"1234567"},"items":[{"name":"pen","quantity":1},{"name":"a very long name","quantity":100}],"labels":{"a":"1","b":"2","c":"3"}}`,
+		expected: []string{"source:oneof", "retry:oneof", "level:oneof", "nickname:min", "billing.city:required", "billing.zip:max", "items[1].name:max", "items[1].quantity:max", "labels:max"}},
+	{name: "too many items", json: `{"name":"Jack","email":"a@b.c","level":1,"address":{"city":"Paris"},"items":[{"name":"a","quantity":1},{"name":"b","quantity":1},{"name":"c","quantity":1},{"name":"d","quantity":1}]}`, expected: []string{"items:max"}},
+	{name: "numeric zeros", json: `{"name":"Jack","email":"a@b.c","level":0,"retry":0,"address":{"city":"Paris"},"items":[{"name":"pen","quantity":0}]}`, expected: []string{"level:oneof", "items[0].quantity:min"}},
+}
+
+func TestTools_Validate(t *testing.T) {
+	var testTools Tools
+	for _, e := range validateTests {
+		var signup testSignup
+		if err := json.Unmarshal([]byte(e.json), &signup); err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		err := testTools.Validate(&signup)
+		var got []string
+		var validationErrors ValidationErrors
+		if errors.As(err, &validationErrors) {
+			for _, fieldError := range validationErrors {
+				got = append(got, fieldError.Field+":"+fieldError.Rule)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_Validate_InvalidTag(t *testing.T) {
+	var testTools Tools
+	var validationErrors ValidationErrors
+	invalid := []interface{}{
+		&struct {
+			Name string `validate:"min=three"`
+		}{Name: "x"},
+		&struct {
+			Name string `validate:"uppercase"`
+		}{Name: "x"},
+		&struct {
+			Flag bool `validate:"max=1"`
+		}{Flag: true},
+	}
+	for i, data := range invalid {
+		err := testTools.Validate(data)
+		if err == nil || errors.As(err, &validationErrors) {
+			t.Errorf("%d: expected a tag error, got %v", i, err)
+		}
+	}
+}
+
+func TestTools_ReadAndValidateJSON(t *testing.T) {
+	var testTools Tools
+	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"name":"Jo","email":"jack@example.com","level":1,"address":{"city":"Paris"},"items":[{"name":"pen","quantity":1}]}`)))
+	rr := httptest.NewRecorder()
+	var signup testSignup
+	err := testTools.ReadAndValidateJSON(rr, req, &signup)
+	if err == nil {
+		t.Fatal("expected a validation error")
+	}
+	if signup.Email != "jack@example.com" {
+		t.Error("body was not decoded")
+	}
+
+	if err = testTools.ErrorJSON(rr, err); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422, got %d", rr.Code)
+	}
+	var payload struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		Data    []FieldError `json:"data"`
+	}
+	if err = json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldError{{Field: "name", Rule: "min", Param: "3", Message: "must be at least 3 characters long"}}
+	if !payload.Error || payload.Message != "name must be at least 3 characters long" || !reflect.DeepEqual(payload.Data, expected) {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
@@ -0,0 +1,133 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// compressedExtensions are stored as is in zip downloads, since deflating them again gains nothing
+var compressedExtensions = map[string]bool{
+	".7z": true, ".avif": true, ".br": true, ".bz2": true, ".docx": true, ".gif": true, ".gz": true,
+	".heic": true, ".jpeg": true, ".jpg": true, ".m4a": true, ".mkv": true, ".mov": true, ".mp3": true,
+	".mp4": true, ".ogg": true, ".pdf": true, ".png": true, ".pptx": true, ".rar": true, ".webm": true,
+	".webp": true, ".xlsx": true, ".xz": true, ".zip": true, ".zst": true,
+}
+
+// ZipEntry is a file to add to a zip download
+type ZipEntry struct {
+	// Path is the stored path of the file, relative to the download root
+	Path string
+	// Name is the name of the file inside the archive; it defaults to the base name of Path
+	Name string
+}
+
+// zipFile is a ZipEntry that was checked and given a unique name inside the archive
+type zipFile struct {
+	path string
+	name string
+	info fs.FileInfo
+}
+
+// serveZip streams the entries from fsys to the client as a zip archive, without temporary files.
+// Every entry is checked before anything is sent, so that a missing file still gets a 404 response.
+func (tools *Tools) serveZip(w http.ResponseWriter, r *http.Request, fsys fs.FS, entries []ZipEntry, archiveName string, storeOnly bool) {
+	if archiveName == "" {
+		archiveName = "download.zip"
+	}
+	w, audit := tools.startAudit(w, r)
+	rec := DownloadRecord{File: archiveName, DisplayName: archiveName}
+	defer func() {
+		audit(rec)
+	}()
+	files := make([]zipFile, 0, len(entries))
+	used := make(map[string]bool)
+	for _, e := range entries {
+		rec.Files = append(rec.Files, e.Path)
+		name, ok := downloadPath(e.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !tools.authorize(w, r, name) {
+			return
+		}
+		info, err := fs.Stat(fsys, name)
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		files = append(files, zipFile{path: name, name: uniqueZipName(zipEntryName(e, name), used), info: info})
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition(false, archiveName))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	zw := zip.NewWriter(throttleResponse(w, r, tools.DownloadThrottle.limiters(r, 0)))
+	for _, f := range files {
+		if err := writeZipFile(zw, fsys, f, storeOnly); err != nil {
+			// the status is already sent, so leave the archive without its central directory for
+			// the client to detect that it is incomplete
+			return
+		}
+	}
+	_ = zw.Close()
+}
+
+// writeZipFile adds a single file to the archive. Files larger than 4 GiB, or archives with more than
+// 65535 entries, are written in the Zip64 format by archive/zip.
+func writeZipFile(zw *zip.Writer, fsys fs.FS, f zipFile, storeOnly bool) error {
+	in, err := fsys.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer func(in fs.File) {
+		_ = in.Close()
+	}(in)
+	header := &zip.FileHeader{
+		Name:     f.name,
+		Method:   zip.Deflate,
+		Modified: f.info.ModTime(),
+	}
+	if storeOnly || compressedExtensions[strings.ToLower(path.Ext(f.name))] {
+		header.Method = zip.Store
+	}
+	header.SetMode(0644)
+	out, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// zipEntryName returns the name of an entry inside the archive, as a relative slash-separated path
+// that cannot be extracted outside of the destination directory
+func zipEntryName(e ZipEntry, storedPath string) string {
+	name := strings.ToValidUTF8(strings.ReplaceAll(e.Name, "\\", "/"), "_")
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = path.Base(storedPath)
+	}
+	return name
+}
+
+// uniqueZipName returns name, or name with a counter before its extension if it is already used
+func uniqueZipName(name string, used map[string]bool) string {
+	candidate := name
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,71 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTools_DownloadZip(t *testing.T) {
+	var testTool Tools
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	testTool.DownloadZip(w, r, "./testdata", []ZipEntry{
+		{Path: "pic.jpg", Name: "puppy.jpg"},
+		{Path: "img.png", Name: "Puppy.jpg"},
+		{Path: "pic.jpg", Name: "../../evil/puppy.jpg"},
+		{Path: "img.png"},
+	}, "pictures.zip")
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status %d", res.StatusCode)
+	}
+	if res.Header.Get("Content-Type") != "application/zip" || res.Header.Get("Content-Disposition") != `attachment; filename="pictures.zip"` {
+		t.Errorf("wrong headers %v", res.Header)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"puppy.jpg", "Puppy (1).jpg", "evil/puppy.jpg", "img.png"}
+	if len(zr.File) != len(expected) {
+		t.Fatalf("expected %d files, got %d", len(expected), len(zr.File))
+	}
+	for i, f := range zr.File {
+		if f.Name != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], f.Name)
+		}
+		if f.Method != zip.Store {
+			t.Errorf("%s: expected compressed media to be stored", f.Name)
+		}
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(rc)
+	_ = rc.Close()
+	if len(content) != 98827 {
+		t.Errorf("wrong size %d for puppy.jpg", len(content))
+	}
+}
+
+func TestTools_DownloadZip_NotFound(t *testing.T) {
+	var testTool Tools
+	for _, entries := range [][]ZipEntry{
+		{{Path: "pic.jpg"}, {Path: "missing.jpg"}},
+		{{Path: "../tools.go"}},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		testTool.DownloadZip(w, r, "./testdata", entries, "pictures.zip")
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%v: expected 404, got %d", entries, w.Code)
+		}
+	}
+}